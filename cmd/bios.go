@@ -15,6 +15,11 @@ func (h *hammer) ConfigureBIOS() error {
 	}
 
 	reboot, err := h.hal.ConfigureBIOS()
+	if err != nil && kernel.Firmware() == "bios" {
+		// some old boards can not be switched to uefi, they are installed with legacy boot instead
+		h.log.Warn("bios", "message", "unable to switch to uefi, continue with legacy boot", "error", err)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jaypipes/ghw"
+	"github.com/metal-stack/metal-go/api/models"
+	"github.com/metal-stack/metal-hammer/cmd/burnin"
+	"github.com/metal-stack/metal-hammer/cmd/event"
+	"github.com/metal-stack/metal-hammer/cmd/storage"
+	"github.com/metal-stack/metal-hammer/pkg/kernel"
+)
+
+// BurnIn stresses disks, cpus and memory if enabled by the kernel commandline or the machine tags.
+// It runs in the waiting phase after the disks are wiped, the disk test zeroes everything it has written.
+// A machine which fails the burn-in is powered off, it must not be allocated and a reboot would only repeat the burn-in.
+func (h *hammer) BurnIn(m *models.V1MachineResponse) error {
+	var tags []string
+	if m != nil {
+		tags = m.Tags
+	}
+	config, err := burnin.ConfigFromTags(tags, h.spec.BurnIn)
+	if err != nil {
+		return err
+	}
+	if !config.Enabled {
+		return nil
+	}
+
+	blockInfo, err := ghw.Block()
+	if err != nil {
+		return fmt.Errorf("unable to get system block devices %w", err)
+	}
+	var disks []burnin.Disk
+	for _, disk := range blockInfo.Disks {
+		if strings.HasPrefix(disk.Name, storage.DiskPrefixToIgnore) {
+			continue
+		}
+		if _, ok := h.spec.ProtectedDisks.Match(disk); ok {
+			continue
+		}
+		disks = append(disks, burnin.Disk{Device: fmt.Sprintf("/dev/%s", disk.Name), Size: disk.SizeBytes})
+	}
+
+	h.eventEmitter.Emit(event.ProvisioningEventWaiting, fmt.Sprintf("start burn-in of %d disks, cpu stress for %s", len(disks), config.Duration))
+	_, err = burnin.New(h.log, config, func(r burnin.Result) {
+		h.eventEmitter.Emit(event.ProvisioningEventWaiting, r.String())
+	}).Run(disks)
+	if err == nil {
+		h.eventEmitter.Emit(event.ProvisioningEventWaiting, "burn-in verdict: passed")
+		return nil
+	}
+
+	h.log.Error("burn-in failed, power off", "error", err)
+	h.eventEmitter.Emit(event.ProvisioningEventWaiting, "burn-in verdict: failed, power off")
+	perr := kernel.PowerOff()
+	// only reached if the power off failed, the crash reboots the machine as a last resort
+	return fmt.Errorf("burn-in failed %w", errors.Join(err, perr))
+}
@@ -0,0 +1,146 @@
+package burnin
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DurationTag enables the burn-in and sets the duration of the cpu stress, e.g. burnin.metal-stack.io/duration=1h
+	DurationTag = "burnin.metal-stack.io/duration"
+
+	mib = 1024 * 1024
+	gib = 1024 * mib
+)
+
+// Config of the burn-in
+type Config struct {
+	Enabled bool
+	// Duration of the cpu stress test
+	Duration time.Duration
+	// DiskBytes is the number of bytes written and verified sequentially at the start of every disk
+	DiskBytes uint64
+	// DiskBlocks is the number of 4KiB blocks written and verified at random offsets of every disk
+	DiskBlocks int
+	// MemoryPercent is the part of the available memory which is tested
+	MemoryPercent uint64
+}
+
+// DefaultConfig is used if the burn-in is enabled without further configuration
+var DefaultConfig = Config{
+	Duration:      10 * time.Minute,
+	DiskBytes:     10 * gib,
+	DiskBlocks:    10000,
+	MemoryPercent: 80,
+}
+
+// ConfigFromTags enables the burn-in if the machine is tagged with DurationTag
+func ConfigFromTags(tags []string, config Config) (Config, error) {
+	for _, tag := range tags {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok || key != DurationTag {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid burn-in duration %q %w", value, err)
+		}
+		config.Enabled = true
+		config.Duration = duration
+	}
+	return config, nil
+}
+
+// Disk to test, all data on the disk is overwritten
+type Disk struct {
+	Device string
+	Size   uint64
+}
+
+// Result of a single test
+type Result struct {
+	Test   string
+	Passed bool
+	Took   time.Duration
+	Error  string
+}
+
+func (r Result) String() string {
+	if r.Passed {
+		return fmt.Sprintf("burn-in %s passed took:%s", r.Test, r.Took.Round(time.Second))
+	}
+	return fmt.Sprintf("burn-in %s failed took:%s error:%s", r.Test, r.Took.Round(time.Second), r.Error)
+}
+
+// BurnIn stresses the disks, cpus and memory of the machine
+type BurnIn struct {
+	log    *slog.Logger
+	config Config
+	// report is called with the result of every test as soon as it is finished
+	report func(Result)
+}
+
+// New creates a burn-in with the given configuration
+func New(log *slog.Logger, config Config, report func(Result)) *BurnIn {
+	return &BurnIn{
+		log:    log,
+		config: config,
+		report: report,
+	}
+}
+
+// Run tests all disks in parallel while the cpus are stressed, the memory is tested afterwards
+// because the cpu stress and the disk buffers would compete for the memory bandwidth.
+// The error contains all failed tests.
+func (b *BurnIn) Run(disks []Disk) ([]Result, error) {
+	var (
+		results []Result
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	run := func(test string, f func() error) {
+		b.log.Info("burn-in", "test", test, "message", "start")
+		start := time.Now()
+		err := f()
+		r := Result{Test: test, Passed: err == nil, Took: time.Since(start)}
+		if err != nil {
+			r.Error = err.Error()
+			b.log.Error("burn-in", "test", test, "error", err)
+		}
+		b.log.Info("burn-in", "result", r.String())
+		if b.report != nil {
+			b.report(r)
+		}
+		mu.Lock()
+		results = append(results, r)
+		mu.Unlock()
+	}
+
+	for _, disk := range disks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run("disk "+disk.Device, func() error { return b.testDisk(disk) })
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		run("cpu", b.testCPU)
+	}()
+	wg.Wait()
+
+	run("memory", b.testMemory)
+
+	var errs []error
+	for _, r := range results {
+		if !r.Passed {
+			errs = append(errs, fmt.Errorf("%s: %s", r.Test, r.Error))
+		}
+	}
+	return results, errors.Join(errs...)
+}
@@ -0,0 +1,106 @@
+package burnin
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFromTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		want    Config
+		wantErr bool
+	}{
+		{
+			name: "not tagged",
+			tags: []string{"rack=r01"},
+			want: DefaultConfig,
+		},
+		{
+			name: "duration",
+			tags: []string{DurationTag + "=2h"},
+			want: Config{Enabled: true, Duration: 2 * time.Hour, DiskBytes: DefaultConfig.DiskBytes, DiskBlocks: DefaultConfig.DiskBlocks, MemoryPercent: DefaultConfig.MemoryPercent},
+		},
+		{
+			name:    "invalid duration",
+			tags:    []string{DurationTag + "=forever"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ConfigFromTags(tt.tags, DefaultConfig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConfigFromTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ConfigFromTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyDisk(t *testing.T) {
+	const size = 10*mib + 4096
+	noFlush := func() error { return nil }
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "disk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = f.Truncate(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = verifySequential(f, size, 42, noFlush)
+	if err != nil {
+		t.Errorf("verifySequential() error = %v", err)
+	}
+	offsets := randomOffsets(size, 100, 42)
+	err = verifyRandom(f, offsets, 42, noFlush)
+	if err != nil {
+		t.Errorf("verifyRandom() error = %v", err)
+	}
+
+	// no test pattern must remain after the disk is cleared
+	err = clearDisk(f, size, offsets)
+	if err != nil {
+		t.Errorf("clearDisk() error = %v", err)
+	}
+	content, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, make([]byte, size)) {
+		t.Error("clearDisk() left the test pattern on the disk")
+	}
+
+	// a disk which drops the writes after flush must be detected
+	corrupt := func() error {
+		_, err := f.WriteAt(make([]byte, size), 0)
+		return err
+	}
+	err = verifySequential(f, size, 42, corrupt)
+	if err == nil || !strings.Contains(err.Error(), "data mismatch") {
+		t.Errorf("verifySequential() error = %v, want data mismatch", err)
+	}
+	err = verifyRandom(f, offsets, 42, corrupt)
+	if err == nil || !strings.Contains(err.Error(), "data mismatch") {
+		t.Errorf("verifyRandom() error = %v, want data mismatch", err)
+	}
+}
+
+func TestVerifyMemory(t *testing.T) {
+	err := verifyMemory(mib + 8)
+	if err != nil {
+		t.Errorf("verifyMemory() error = %v", err)
+	}
+}
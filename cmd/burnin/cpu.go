@@ -0,0 +1,75 @@
+package burnin
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// cpuBufferSize is the size of the data which is hashed by every worker in a loop
+const cpuBufferSize = mib
+
+// testCPU keeps all cpus busy with hashing and floating point calculations for the configured duration
+// and compares every result with a reference, a mismatch indicates a faulty or overheating cpu.
+func (b *BurnIn) testCPU() error {
+	buf := make([]byte, cpuBufferSize)
+	fillPattern(buf, 0, 0)
+	hash := sha256.Sum256(buf)
+	float := floatWork()
+
+	workers := runtime.NumCPU()
+	b.log.Info("burn-in", "test", "cpu", "workers", workers, "duration", b.config.Duration)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   []error
+		rounds uint64
+	)
+	deadline := time.Now().Add(b.config.Duration)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var n uint64
+			for time.Now().Before(deadline) {
+				if sha256.Sum256(buf) != hash {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("worker %d: hash mismatch in round %d", i, n))
+					mu.Unlock()
+					return
+				}
+				if f := floatWork(); f != float {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("worker %d: floating point result %v differs from %v in round %d", i, f, float, n))
+					mu.Unlock()
+					return
+				}
+				n++
+			}
+			mu.Lock()
+			rounds += n
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	b.log.Info("burn-in", "test", "cpu", "rounds", rounds)
+	return nil
+}
+
+// floatWork is a deterministic floating point calculation
+func floatWork() float64 {
+	sum := 0.0
+	for i := 1; i <= 100000; i++ {
+		x := float64(i)
+		sum += math.Sqrt(x) * math.Sin(x) / math.Log(x+1)
+	}
+	return sum
+}
@@ -0,0 +1,188 @@
+package burnin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// diskChunkSize is the size of a single sequential write or read
+	diskChunkSize = 4 * mib
+	// diskBlockSize is the size of a random write or read
+	diskBlockSize = 4096
+)
+
+// readerWriterAt is implemented by os.File
+type readerWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// testDisk writes a pattern sequentially to the start of the disk and to random blocks all over the disk
+// and reads it back after the buffers are flushed. The burn-in runs after the wipe,
+// all written regions are zeroed afterwards so no test pattern remains on the disk.
+func (b *BurnIn) testDisk(disk Disk) (err error) {
+	f, err := os.OpenFile(disk.Device, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open %s %w", disk.Device, err)
+	}
+	defer f.Close()
+
+	// reads must be served by the disk and not by the page cache
+	flush := func() error {
+		err := f.Sync()
+		if err != nil {
+			return err
+		}
+		return unix.IoctlSetInt(int(f.Fd()), unix.BLKFLSBUF, 0)
+	}
+
+	seed := rand.Uint64() // nolint:gosec
+	size := min(disk.Size, b.config.DiskBytes)
+	offsets := randomOffsets(disk.Size, b.config.DiskBlocks, seed)
+	defer func() {
+		cerr := clearDisk(f, size, offsets)
+		if cerr == nil {
+			cerr = f.Sync()
+		}
+		if cerr != nil {
+			err = errors.Join(err, fmt.Errorf("unable to clear test pattern %w", cerr))
+		}
+	}()
+
+	err = verifySequential(f, size, seed, flush)
+	if err != nil {
+		return fmt.Errorf("sequential %w", err)
+	}
+	b.log.Info("burn-in", "disk", disk.Device, "message", "sequential verification finished")
+
+	err = verifyRandom(f, offsets, seed, flush)
+	if err != nil {
+		return fmt.Errorf("random %w", err)
+	}
+	return nil
+}
+
+// randomOffsets returns the block aligned offsets of the random verification, none if the disk is smaller than a block
+func randomOffsets(size uint64, blocks int, seed uint64) []uint64 {
+	if size < diskBlockSize {
+		return nil
+	}
+	r := rand.New(rand.NewPCG(seed, seed)) // nolint:gosec
+	offsets := make([]uint64, blocks)
+	for i := range offsets {
+		offsets[i] = r.Uint64N(size/diskBlockSize) * diskBlockSize
+	}
+	return offsets
+}
+
+// clearDisk zeroes the sequentially written start of the disk and the randomly written blocks
+func clearDisk(f io.WriterAt, size uint64, offsets []uint64) error {
+	zero := make([]byte, diskChunkSize)
+	for offset := uint64(0); offset < size; offset += diskChunkSize {
+		n := min(diskChunkSize, size-offset)
+		_, err := f.WriteAt(zero[:n], int64(offset)) // nolint:gosec
+		if err != nil {
+			return fmt.Errorf("write at %d failed %w", offset, err)
+		}
+	}
+	for _, offset := range offsets {
+		_, err := f.WriteAt(zero[:diskBlockSize], int64(offset)) // nolint:gosec
+		if err != nil {
+			return fmt.Errorf("write at %d failed %w", offset, err)
+		}
+	}
+	return nil
+}
+
+// verifySequential writes size bytes from the start and compares them after flush
+func verifySequential(f io.ReadWriteSeeker, size, seed uint64, flush func() error) error {
+	buf := make([]byte, diskChunkSize)
+	expected := make([]byte, diskChunkSize)
+
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	for offset := uint64(0); offset < size; offset += diskChunkSize {
+		n := min(diskChunkSize, size-offset)
+		fillPattern(buf[:n], offset, seed)
+		_, err := f.Write(buf[:n])
+		if err != nil {
+			return fmt.Errorf("write at %d failed %w", offset, err)
+		}
+	}
+	err = flush()
+	if err != nil {
+		return fmt.Errorf("unable to flush %w", err)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	for offset := uint64(0); offset < size; offset += diskChunkSize {
+		n := min(diskChunkSize, size-offset)
+		_, err := io.ReadFull(f, buf[:n])
+		if err != nil {
+			return fmt.Errorf("read at %d failed %w", offset, err)
+		}
+		fillPattern(expected[:n], offset, seed)
+		if !bytes.Equal(buf[:n], expected[:n]) {
+			return fmt.Errorf("data mismatch in %d bytes at %d", n, offset)
+		}
+	}
+	return nil
+}
+
+// verifyRandom writes blocks at the given offsets and compares them after flush,
+// the pattern depends on the offset only, overlapping blocks therefore contain the same data.
+func verifyRandom(f readerWriterAt, offsets []uint64, seed uint64, flush func() error) error {
+	buf := make([]byte, diskBlockSize)
+	expected := make([]byte, diskBlockSize)
+	for _, offset := range offsets {
+		fillPattern(buf, offset, seed)
+		_, err := f.WriteAt(buf, int64(offset)) // nolint:gosec
+		if err != nil {
+			return fmt.Errorf("write at %d failed %w", offset, err)
+		}
+	}
+	err := flush()
+	if err != nil {
+		return fmt.Errorf("unable to flush %w", err)
+	}
+	for _, offset := range offsets {
+		_, err := f.ReadAt(buf, int64(offset)) // nolint:gosec
+		if err != nil {
+			return fmt.Errorf("read at %d failed %w", offset, err)
+		}
+		fillPattern(expected, offset, seed)
+		if !bytes.Equal(buf, expected) {
+			return fmt.Errorf("data mismatch in block at %d", offset)
+		}
+	}
+	return nil
+}
+
+// fillPattern fills buf with pseudo random data which depends on the seed and the offset on the disk,
+// data written to a wrong offset is detected as well as flipped bits.
+// buf and offset must be aligned to 8 bytes.
+func fillPattern(buf []byte, offset, seed uint64) {
+	for i := 0; i+8 <= len(buf); i += 8 {
+		binary.LittleEndian.PutUint64(buf[i:], splitmix64(seed^(offset+uint64(i)))) // nolint:gosec
+	}
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
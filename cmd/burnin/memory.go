@@ -0,0 +1,70 @@
+package burnin
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"golang.org/x/sys/unix"
+)
+
+// memoryChunkWords is the number of 64bit words of a single allocation
+const memoryChunkWords = 64 * mib / 8
+
+// memoryPatterns are written to every word, the address pattern is added to detect address line faults
+var memoryPatterns = []struct {
+	name  string
+	value func(i uint64) uint64
+}{
+	{name: "zeros", value: func(uint64) uint64 { return 0 }},
+	{name: "ones", value: func(uint64) uint64 { return ^uint64(0) }},
+	{name: "checkerboard", value: func(uint64) uint64 { return 0x5555555555555555 }},
+	{name: "inverse checkerboard", value: func(uint64) uint64 { return 0xaaaaaaaaaaaaaaaa }},
+	{name: "walking ones", value: func(i uint64) uint64 { return 1 << (i % 64) }},
+	{name: "address", value: func(i uint64) uint64 { return splitmix64(i) }},
+}
+
+// testMemory allocates the configured part of the available memory and writes and verifies every pattern
+func (b *BurnIn) testMemory() error {
+	var info unix.Sysinfo_t
+	err := unix.Sysinfo(&info)
+	if err != nil {
+		return fmt.Errorf("unable to read available memory %w", err)
+	}
+	available := uint64(info.Freeram) * uint64(info.Unit) // nolint:gosec
+	size := available / 100 * b.config.MemoryPercent
+	b.log.Info("burn-in", "test", "memory", "available", available, "testing", size)
+
+	// the memory is returned to the os, the installation afterwards needs it
+	defer debug.FreeOSMemory()
+	return verifyMemory(size)
+}
+
+// verifyMemory writes and verifies all patterns in size bytes of memory
+func verifyMemory(size uint64) error {
+	var chunks [][]uint64
+	for words := size / 8; words > 0; {
+		n := min(words, memoryChunkWords)
+		chunks = append(chunks, make([]uint64, n))
+		words -= n
+	}
+
+	for _, p := range memoryPatterns {
+		var i uint64
+		for _, c := range chunks {
+			for j := range c {
+				c[j] = p.value(i)
+				i++
+			}
+		}
+		i = 0
+		for _, c := range chunks {
+			for j := range c {
+				if c[j] != p.value(i) {
+					return fmt.Errorf("pattern %s: word %d is 0x%x, expected 0x%x", p.name, i, c[j], p.value(i))
+				}
+				i++
+			}
+		}
+	}
+	return nil
+}
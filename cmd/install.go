@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -11,6 +12,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/metal-stack/metal-hammer/cmd/event"
 	"github.com/metal-stack/metal-hammer/cmd/utils"
 	"github.com/metal-stack/metal-hammer/pkg/api"
 
@@ -41,6 +43,12 @@ func (h *hammer) Install(machine *models.V1MachineResponse) (*api.Bootinfo, erro
 		return nil, err
 	}
 
+	// mdadm.conf must be present before the installer creates the initrd
+	err = s.CreateMdadmConf()
+	if err != nil {
+		return nil, err
+	}
+
 	info, err := h.install(h.chrootPrefix, machine, s.RootUUID)
 	if err != nil {
 		return nil, err
@@ -235,6 +243,7 @@ func (h *hammer) writeInstallerConfig(machine *models.V1MachineResponse, rootUUi
 		FirewallRules: alloc.FirewallRules,
 		DNSServers:    alloc.DNSServers,
 		NTPServers:    alloc.NtpServers,
+		Firmware:      kernel.Firmware(),
 	}
 
 	yamlContent, err := yaml.Marshal(y)
@@ -269,6 +278,35 @@ func (h *hammer) onlyNicsWithNeighbors(nics []*models.V1MachineNic) []*models.V1
 	return result
 }
 
+// waitForRaidSync emits the sync progress of all md arrays and waits until they are in sync if configured.
+// The sync continues after booting into the new kernel if not waited for.
+func (h *hammer) waitForRaidSync() {
+	if h.filesystemLayout == nil || len(h.filesystemLayout.Raid) == 0 {
+		return
+	}
+
+	progress := func(arrays []storage.MDArray) {
+		for _, a := range arrays {
+			h.eventEmitter.Emit(event.ProvisioningEventInstalling, fmt.Sprintf("raid sync %s", a))
+		}
+	}
+
+	if h.spec.RaidResyncWait <= 0 {
+		syncing, err := storage.ArraysNotInSync()
+		if err != nil {
+			h.log.Error("unable to read raid sync state", "error", err)
+			return
+		}
+		progress(syncing)
+		return
+	}
+
+	err := storage.WaitForRaidSync(h.log, time.Minute, h.spec.RaidResyncWait, progress)
+	if err != nil {
+		h.log.Warn("raid sync not finished, continue with booting", "error", err)
+	}
+}
+
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	if os.IsNotExist(err) {
@@ -276,3 +314,27 @@ func fileExists(filename string) bool {
 	}
 	return !info.IsDir()
 }
+
+// reportWipeCertificates sends the result and the certificate of every disk as event to metal-api where it is stored in the event log of the machine.
+// The results are not part of the registration, the machine is registered before its disks are wiped and the register request
+// of the boot service has no field for them. With the strict wipe policy a failed wipe aborts the provisioning with a crashed event,
+// metal-api then detects the crash loop of the machine.
+func (h *hammer) reportWipeCertificates(certificates []api.WipeCertificate) {
+	for _, c := range certificates {
+		j, err := json.Marshal(c)
+		if err != nil {
+			h.log.Error("unable to marshal wipe certificate", "device", c.Device, "error", err)
+			continue
+		}
+		h.eventEmitter.Emit(event.ProvisioningEventPreparing, fmt.Sprintf("wipe result device:%s method:%s took:%s bytes:%d verified:%t error:%q certificate:%s",
+			c.Device, c.Method, c.End.Sub(c.Start), c.Size, c.Verified, c.Error, j))
+	}
+	for _, t := range storage.WipeSummary(certificates) {
+		h.eventEmitter.Emit(event.ProvisioningEventPreparing, "wipe throughput "+t.String())
+	}
+}
+
+// reportWipeProgress sends the progress of a disk wipe as event to metal-api
+func (h *hammer) reportWipeProgress(p storage.WipeProgress) {
+	h.eventEmitter.Emit(event.ProvisioningEventPreparing, "wipe progress "+p.String())
+}
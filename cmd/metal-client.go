@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -16,9 +17,10 @@ import (
 )
 
 type MetalAPIClient struct {
-	log    *slog.Logger
-	conn   grpc.ClientConnInterface
-	driver metalgo.Client
+	log        *slog.Logger
+	conn       grpc.ClientConnInterface
+	driver     metalgo.Client
+	clientCert tls.Certificate
 }
 
 // NewMetalAPIClient fetches the address,hmac and certificates from pixie needed to communicate with metal-api,
@@ -65,9 +67,10 @@ func NewMetalAPIClient(log *slog.Logger, spec *Specification) (*MetalAPIClient,
 	}
 
 	return &MetalAPIClient{
-		log:    log,
-		conn:   conn,
-		driver: driver,
+		log:        log,
+		conn:       conn,
+		driver:     driver,
+		clientCert: clientCert,
 	}, nil
 }
 func (c *MetalAPIClient) Machine() machine.ClientService {
@@ -80,3 +83,17 @@ func (c *MetalAPIClient) Event() v1.EventServiceClient {
 func (c *MetalAPIClient) BootService() v1.BootServiceClient {
 	return v1.NewBootServiceClient(c.conn)
 }
+
+// Signer returns the key of the client certificate and the subject of the certificate,
+// it is used to sign documents which are reported to metal-api.
+func (c *MetalAPIClient) Signer() (crypto.Signer, string, error) {
+	signer, ok := c.clientCert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, "", errors.New("client certificate key is not able to sign")
+	}
+	leaf, err := x509.ParseCertificate(c.clientCert.Certificate[0])
+	if err != nil {
+		return nil, "", err
+	}
+	return signer, leaf.Subject.String(), nil
+}
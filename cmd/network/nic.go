@@ -0,0 +1,145 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/metal-stack/metal-hammer/pkg/os"
+)
+
+// sysClassNet contains a directory per network interface
+var sysClassNet = "/sys/class/net"
+
+// NICDetails are the hardware details of a network interface which are not part of the registration
+type NICDetails struct {
+	Name            string `json:"name"`
+	Mac             string `json:"mac"`
+	Driver          string `json:"driver"`
+	DriverVersion   string `json:"driver_version"`
+	FirmwareVersion string `json:"firmware_version"`
+	// Speed is the negotiated speed in Mbit/s, zero if the link is down
+	Speed int `json:"speed"`
+	// SupportedSpeeds in Mbit/s as reported by the supported link modes
+	SupportedSpeeds []int  `json:"supported_speeds"`
+	PCIAddress      string `json:"pci_address"`
+	NUMANode        int    `json:"numa_node"`
+	// SRIOVTotalVFs is the number of virtual functions the nic supports, zero if sr-iov is not supported
+	SRIOVTotalVFs int `json:"sriov_totalvfs"`
+	SRIOVNumVFs   int `json:"sriov_numvfs"`
+}
+
+func (d NICDetails) String() string {
+	return fmt.Sprintf("%s mac:%s driver:%s %s firmware:%s speed:%d supported:%v pci:%s numa:%d sriov:%d/%d",
+		d.Name, d.Mac, d.Driver, d.DriverVersion, d.FirmwareVersion, d.Speed, d.SupportedSpeeds, d.PCIAddress, d.NUMANode, d.SRIOVNumVFs, d.SRIOVTotalVFs)
+}
+
+// NICDetails reads the driver and link information with ethtool and the pci attributes from sysfs.
+// Interfaces without a device, e.g. bridges or bonds, are returned with the driver information only.
+func (n *Network) NICDetails(name, mac string) NICDetails {
+	e := &Ethtool{command: ethtoolCommand, log: n.Log}
+	d := NICDetails{Name: name, Mac: mac, NUMANode: -1}
+
+	output, err := e.Run("-i", name)
+	if err != nil {
+		n.Log.Warn("nic", "interface", name, "message", "unable to read driver information", "error", err)
+	} else {
+		info := parseEthtoolDriverInfo(output)
+		d.Driver = info["driver"]
+		d.DriverVersion = info["version"]
+		d.FirmwareVersion = info["firmware-version"]
+	}
+
+	output, err = e.Run(name)
+	if err != nil {
+		n.Log.Warn("nic", "interface", name, "message", "unable to read link modes", "error", err)
+	} else {
+		d.SupportedSpeeds = parseEthtoolSupportedSpeeds(output)
+	}
+
+	readNICSysfs(filepath.Join(sysClassNet, name), &d)
+	return d
+}
+
+// readNICSysfs adds the negotiated speed and the attributes of the pci device
+func readNICSysfs(dir string, d *NICDetails) {
+	if speed, err := strconv.Atoi(os.ReadSysfs(dir, "speed")); err == nil && speed > 0 {
+		d.Speed = speed
+	}
+	device, err := filepath.EvalSymlinks(filepath.Join(dir, "device"))
+	if err != nil {
+		return
+	}
+	d.PCIAddress = filepath.Base(device)
+	if node, err := strconv.Atoi(os.ReadSysfs(device, "numa_node")); err == nil {
+		d.NUMANode = node
+	}
+	if vfs, err := strconv.Atoi(os.ReadSysfs(device, "sriov_totalvfs")); err == nil {
+		d.SRIOVTotalVFs = vfs
+	}
+	if vfs, err := strconv.Atoi(os.ReadSysfs(device, "sriov_numvfs")); err == nil {
+		d.SRIOVNumVFs = vfs
+	}
+}
+
+// parseEthtoolDriverInfo parses the key: value lines of ethtool -i
+//
+//	driver: ice
+//	version: 6.6.0
+//	firmware-version: 4.40 0x8001b8ba 1.3534.0
+//	bus-info: 0000:3b:00.0
+func parseEthtoolDriverInfo(output string) map[string]string {
+	info := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		info[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return info
+}
+
+// parseEthtoolSupportedSpeeds returns the distinct speeds of the supported link modes, the modes continue on
+// the following lines until the next key:
+//
+//	Supported link modes:   1000baseT/Full
+//	                        10000baseT/Full
+//	                        25000baseCR/Full
+//	Supported pause frame use: Symmetric
+func parseEthtoolSupportedSpeeds(output string) []int {
+	var (
+		speeds  []int
+		inModes bool
+	)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if modes, ok := strings.CutPrefix(line, "Supported link modes:"); ok {
+			inModes = true
+			line = modes
+		} else if strings.Contains(line, ":") {
+			inModes = false
+		}
+		if !inModes {
+			continue
+		}
+		for _, mode := range strings.Fields(line) {
+			digits := strings.IndexFunc(mode, func(r rune) bool { return r < '0' || r > '9' })
+			if digits <= 0 {
+				continue
+			}
+			speed, err := strconv.Atoi(mode[:digits])
+			if err != nil || slices.Contains(speeds, speed) {
+				continue
+			}
+			speeds = append(speeds, speed)
+		}
+	}
+	slices.Sort(speeds)
+	return speeds
+}
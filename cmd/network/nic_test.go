@@ -0,0 +1,100 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseEthtoolDriverInfo(t *testing.T) {
+	output := `driver: ice
+version: 6.6.0
+firmware-version: 4.40 0x8001b8ba 1.3534.0
+expansion-rom-version:
+bus-info: 0000:3b:00.0
+supports-statistics: yes
+`
+	info := parseEthtoolDriverInfo(output)
+	for key, want := range map[string]string{
+		"driver":                "ice",
+		"version":               "6.6.0",
+		"firmware-version":      "4.40 0x8001b8ba 1.3534.0",
+		"expansion-rom-version": "",
+		"bus-info":              "0000:3b:00.0",
+	} {
+		if got := info[key]; got != want {
+			t.Errorf("parseEthtoolDriverInfo()[%s] = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestParseEthtoolSupportedSpeeds(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []int
+	}{
+		{
+			name: "multiple lines",
+			output: `Settings for eth0:
+	Supported ports: [ FIBRE ]
+	Supported link modes:   1000baseKX/Full
+	                        10000baseKR/Full
+	                        25000baseCR/Full
+	                        25000baseSR/Full
+	Supported pause frame use: Symmetric
+	Supports auto-negotiation: Yes
+	Advertised link modes:  25000baseCR/Full
+	Speed: 25000Mb/s
+`,
+			want: []int{1000, 10000, 25000},
+		},
+		{
+			name: "not reported",
+			output: `Settings for eth0:
+	Supported link modes:   Not reported
+	Speed: Unknown!
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseEthtoolSupportedSpeeds(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEthtoolSupportedSpeeds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadNICSysfs(t *testing.T) {
+	root := t.TempDir()
+	device := filepath.Join(root, "devices", "0000:3b:00.0")
+	nic := filepath.Join(root, "net", "eth0")
+	for dir, attributes := range map[string]map[string]string{
+		device: {"numa_node": "1\n", "sriov_totalvfs": "128\n", "sriov_numvfs": "0\n"},
+		nic:    {"speed": "25000\n"},
+	} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for attribute, value := range attributes {
+			err := os.WriteFile(filepath.Join(dir, attribute), []byte(value), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	err := os.Symlink(device, filepath.Join(nic, "device"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NICDetails{Name: "eth0", NUMANode: -1}
+	readNICSysfs(nic, &d)
+	want := NICDetails{Name: "eth0", Speed: 25000, PCIAddress: "0000:3b:00.0", NUMANode: 1, SRIOVTotalVFs: 128}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("readNICSysfs() = %v, want %v", d, want)
+	}
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/metal-stack/metal-go/api/client/machine"
+	"github.com/metal-stack/metal-hammer/cmd/event"
+	"github.com/metal-stack/metal-hammer/cmd/raidcontroller"
+)
+
+// ConfigureRaidController creates the virtual drives or switches the raid controllers to jbod
+// as requested by the machine tags. This must be done before the machine is registered and the disks are wiped
+// because the disks are exported by the controller, both read the block devices again afterwards.
+// The tags are therefore read from the machine before it is allocated, a machine which is not known by the
+// metal-api yet keeps the configuration of its controllers. A reinstall must not touch the existing virtual drives.
+func (h *hammer) ConfigureRaidController() error {
+	if h.hal.Board().VM {
+		return nil
+	}
+
+	resp, err := h.metalAPIClient.Machine().FindMachine(machine.NewFindMachineParams().WithID(h.spec.MachineUUID), nil)
+	if err != nil {
+		h.log.Info("raidcontroller", "message", "machine is not registered yet, keep raid controller configuration", "error", err)
+		return nil
+	}
+	m := resp.Payload
+	if m == nil {
+		return nil
+	}
+	if m.Allocation != nil && m.Allocation.Reinstall != nil && *m.Allocation.Reinstall {
+		h.log.Info("raidcontroller", "message", "reinstall, keep raid controller configuration")
+		return nil
+	}
+
+	config, err := raidcontroller.ConfigFromTags(m.Tags)
+	if err != nil {
+		return err
+	}
+
+	rc, ok := raidcontroller.New(h.log)
+	if !ok {
+		// the cli is not part of every initrd, a machine which requests a configuration must not be provisioned without it
+		if config.Mode != raidcontroller.ModeKeep {
+			return fmt.Errorf("raid controller mode %s requested by tag %s but none of %v is present", config.Mode, raidcontroller.ModeTag, raidcontroller.CLIs)
+		}
+		h.log.Info("raidcontroller", "message", "no raid controller cli present, skipping")
+		return nil
+	}
+
+	err = rc.Configure(config)
+	if err != nil {
+		return err
+	}
+	if config.Mode != raidcontroller.ModeKeep {
+		h.eventEmitter.Emit(event.ProvisioningEventPreparing, fmt.Sprintf("raid controller configured in %s mode", config.Mode))
+	}
+	return nil
+}
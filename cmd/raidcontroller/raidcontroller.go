@@ -0,0 +1,397 @@
+package raidcontroller
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ModeTag selects how the raid controllers are configured, one of keep, jbod or raid.
+	ModeTag = "raidcontroller.metal-stack.io/mode"
+	// VirtualDrivesTag lists the virtual drives to create in raid mode, e.g. raid1:2,raid5:*
+	// every entry is the raid type and the number of drives, * takes all remaining drives.
+	VirtualDrivesTag = "raidcontroller.metal-stack.io/virtualdrives"
+)
+
+// Mode of the raid controller configuration
+type Mode string
+
+const (
+	// ModeKeep leaves the controller configuration untouched, this is the default
+	ModeKeep Mode = "keep"
+	// ModeJBOD exports all drives directly to the os
+	ModeJBOD Mode = "jbod"
+	// ModeRaid creates the configured virtual drives
+	ModeRaid Mode = "raid"
+)
+
+var (
+	// CLIs are the supported controller cli tools, storcli for broadcom/lsi and perccli for dell controllers.
+	// They are not redistributable and only part of the initrd if provided at build time.
+	CLIs = []string{"storcli64", "storcli", "perccli64", "perccli"}
+	// scsiHosts is scanned after the configuration changed to make new virtual drives visible
+	scsiHosts = "/sys/class/scsi_host"
+	// sysBlock contains an entry per block device
+	sysBlock = "/sys/block"
+	// runCLI runs the controller cli, tests replace it to record the commands
+	runCLI = func(cli string, args ...string) ([]byte, error) {
+		return exec.Command(cli, args...).Output() // nolint:gosec
+	}
+)
+
+const (
+	// settleTimeout is the maximum time to wait for the block devices after a rescan
+	settleTimeout = 30 * time.Second
+	// settleInterval is the time between two checks of the block devices
+	settleInterval = 500 * time.Millisecond
+	// settleQuiet is the time the block devices must not change to be considered settled
+	settleQuiet = 3 * time.Second
+)
+
+// Config is the desired configuration of all raid controllers
+type Config struct {
+	Mode          Mode
+	VirtualDrives []VirtualDriveSpec
+}
+
+// VirtualDriveSpec describes a virtual drive to create
+type VirtualDriveSpec struct {
+	// Type is the raid type understood by the controller, e.g. raid0, raid1, raid5, raid6, raid10
+	Type string
+	// Drives is the number of physical drives, zero means all remaining drives
+	Drives int
+}
+
+// Controller is a raid controller with its physical and virtual drives
+type Controller struct {
+	Index          int
+	Model          string
+	Serial         string
+	Firmware       string
+	PhysicalDrives []PhysicalDrive
+	VirtualDrives  []VirtualDrive
+}
+
+// PhysicalDrive attached to a controller
+type PhysicalDrive struct {
+	// EnclosureSlot like 252:0 which is used to address the drive
+	EnclosureSlot string
+	State         string
+	Size          string
+	Interface     string
+	Medium        string
+	Model         string
+}
+
+// VirtualDrive exported by a controller
+type VirtualDrive struct {
+	Name  string
+	Type  string
+	State string
+	Size  string
+	// Drives is the number of physical drives in the drive group of the virtual drive
+	Drives int
+}
+
+// RaidController configures all raid controllers of the machine with the vendor cli
+type RaidController struct {
+	log *slog.Logger
+	cli string
+}
+
+// New returns a RaidController, ok is false if no supported cli is present.
+func New(log *slog.Logger) (*RaidController, bool) {
+	for _, c := range CLIs {
+		path, err := exec.LookPath(c)
+		if err == nil {
+			return &RaidController{log: log, cli: path}, true
+		}
+	}
+	return nil, false
+}
+
+// ConfigFromTags reads the desired configuration from the machine tags.
+func ConfigFromTags(tags []string) (*Config, error) {
+	config := &Config{Mode: ModeKeep}
+	for _, tag := range tags {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case ModeTag:
+			switch Mode(value) {
+			case ModeKeep, ModeJBOD, ModeRaid:
+				config.Mode = Mode(value)
+			default:
+				return nil, fmt.Errorf("unsupported raid controller mode %q", value)
+			}
+		case VirtualDrivesTag:
+			vds, err := parseVirtualDrives(value)
+			if err != nil {
+				return nil, err
+			}
+			config.VirtualDrives = vds
+		}
+	}
+	if config.Mode == ModeRaid && len(config.VirtualDrives) == 0 {
+		return nil, fmt.Errorf("raid controller mode %s requires %s", ModeRaid, VirtualDrivesTag)
+	}
+	return config, nil
+}
+
+func parseVirtualDrives(value string) ([]VirtualDriveSpec, error) {
+	var (
+		vds  []VirtualDriveSpec
+		rest bool
+	)
+	for _, entry := range strings.Split(value, ",") {
+		t, n, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || t == "" {
+			return nil, fmt.Errorf("invalid virtual drive %q, must be type:drives", entry)
+		}
+		if rest {
+			return nil, fmt.Errorf("virtual drive %q follows a virtual drive with all remaining drives", entry)
+		}
+		vd := VirtualDriveSpec{Type: strings.ToLower(t)}
+		if n == "*" {
+			rest = true
+		} else {
+			drives, err := strconv.Atoi(n)
+			if err != nil || drives < 1 {
+				return nil, fmt.Errorf("invalid number of drives in virtual drive %q", entry)
+			}
+			vd.Drives = drives
+		}
+		vds = append(vds, vd)
+	}
+	return vds, nil
+}
+
+// Inventory returns all controllers with their drives
+func (r *RaidController) Inventory() ([]Controller, error) {
+	out, err := r.run("/call", "show", "all", "J")
+	if err != nil {
+		return nil, err
+	}
+	return parseControllers(out)
+}
+
+// Configure applies the configuration to all controllers. Controllers which already match the configuration are left
+// untouched, the existing virtual drives and foreign configurations of all others are removed.
+func (r *RaidController) Configure(config *Config) error {
+	controllers, err := r.Inventory()
+	if err != nil {
+		return err
+	}
+	for _, c := range controllers {
+		r.log.Info("raid controller", "controller", c.Index, "model", c.Model, "serial", c.Serial, "firmware", c.Firmware,
+			"physical drives", len(c.PhysicalDrives), "virtual drives", len(c.VirtualDrives))
+		for _, pd := range c.PhysicalDrives {
+			r.log.Info("raid controller drive", "controller", c.Index, "drive", pd.EnclosureSlot, "state", pd.State, "size", pd.Size, "interface", pd.Interface, "medium", pd.Medium, "model", pd.Model)
+		}
+	}
+	if config.Mode == ModeKeep {
+		return nil
+	}
+
+	changed := false
+	for _, c := range controllers {
+		if configured(c, config) {
+			r.log.Info("raid controller already configured", "controller", c.Index, "mode", config.Mode)
+			continue
+		}
+		err := r.configure(c, config)
+		if err != nil {
+			return fmt.Errorf("unable to configure raid controller %d %w", c.Index, err)
+		}
+		changed = true
+	}
+	if changed {
+		r.rescan()
+	}
+	return nil
+}
+
+// configured returns true if the virtual drives or the jbod drives of the controller match the configuration,
+// drives which are neither part of a virtual drive nor unconfigured, e.g. failed drives, are not considered.
+func configured(c Controller, config *Config) bool {
+	switch config.Mode {
+	case ModeJBOD:
+		if len(c.VirtualDrives) > 0 {
+			return false
+		}
+		for _, pd := range c.PhysicalDrives {
+			if pd.State == "UGood" {
+				return false
+			}
+		}
+		return true
+	case ModeRaid:
+		if len(c.VirtualDrives) != len(config.VirtualDrives) {
+			return false
+		}
+		usable := 0
+		for _, pd := range c.PhysicalDrives {
+			switch pd.State {
+			case "UGood", "Onln", "JBOD":
+				usable++
+			}
+		}
+		for i, spec := range config.VirtualDrives {
+			vd := c.VirtualDrives[i]
+			n := spec.Drives
+			if n == 0 {
+				n = usable
+			}
+			if !strings.EqualFold(vd.Type, spec.Type) || vd.Drives != n {
+				return false
+			}
+			usable -= n
+		}
+		return true
+	}
+	return true
+}
+
+func (r *RaidController) configure(c Controller, config *Config) error {
+	ctrl := fmt.Sprintf("/c%d", c.Index)
+
+	// a foreign config exists if drives were moved from another controller, it is not an error if there is none
+	_, err := r.run(ctrl+"/fall", "delete")
+	if err != nil {
+		r.log.Warn("unable to delete foreign configuration, ignoring", "controller", c.Index, "error", err)
+	}
+	if len(c.VirtualDrives) > 0 {
+		_, err = r.run(ctrl+"/vall", "del", "force")
+		if err != nil {
+			return fmt.Errorf("unable to delete virtual drives %w", err)
+		}
+	}
+
+	switch config.Mode {
+	case ModeJBOD:
+		_, err = r.run(ctrl, "set", "jbod=on")
+		if err != nil {
+			return fmt.Errorf("unable to enable jbod %w", err)
+		}
+		// drives which are already jbod are skipped, the controller refuses to set them again
+		for _, pd := range c.PhysicalDrives {
+			if pd.State != "UGood" && pd.State != "Onln" {
+				continue
+			}
+			_, err = r.run(driveAddress(ctrl, pd.EnclosureSlot), "set", "jbod")
+			if err != nil {
+				return fmt.Errorf("unable to set drive %s to jbod %w", pd.EnclosureSlot, err)
+			}
+		}
+	case ModeRaid:
+		commands, err := virtualDriveCommands(ctrl, config.VirtualDrives, c.PhysicalDrives)
+		if err != nil {
+			return err
+		}
+		for _, args := range commands {
+			_, err = r.run(args...)
+			if err != nil {
+				return fmt.Errorf("unable to create virtual drives %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// virtualDriveCommands distributes the drives in slot order to the virtual drives.
+// Drives which are in use by the removed virtual drives are usable as well, jbod drives are
+// set to unconfigured good first because the controller does not add them to a virtual drive.
+func virtualDriveCommands(ctrl string, specs []VirtualDriveSpec, pds []PhysicalDrive) ([][]string, error) {
+	var (
+		free []string
+		jbod = map[string]bool{}
+	)
+	for _, pd := range pds {
+		switch pd.State {
+		case "UGood", "Onln":
+			free = append(free, pd.EnclosureSlot)
+		case "JBOD":
+			free = append(free, pd.EnclosureSlot)
+			jbod[pd.EnclosureSlot] = true
+		}
+	}
+
+	var commands [][]string
+	for _, vd := range specs {
+		n := vd.Drives
+		if n == 0 {
+			n = len(free)
+		}
+		if n == 0 || n > len(free) {
+			return nil, fmt.Errorf("virtual drive %s requires %d drives, only %d are available", vd.Type, n, len(free))
+		}
+		for _, slot := range free[:n] {
+			if jbod[slot] {
+				commands = append(commands, []string{driveAddress(ctrl, slot), "set", "good", "force"})
+			}
+		}
+		commands = append(commands, []string{ctrl, "add", "vd", "type=" + vd.Type, "drives=" + strings.Join(free[:n], ",")})
+		free = free[n:]
+	}
+	return commands, nil
+}
+
+// driveAddress returns the cli address of a drive, e.g. /c0/e252/s3 for the enclosure slot 252:3
+func driveAddress(ctrl, enclosureSlot string) string {
+	enclosure, slot, _ := strings.Cut(enclosureSlot, ":")
+	return fmt.Sprintf("%s/e%s/s%s", ctrl, enclosure, slot)
+}
+
+// rescan all scsi hosts to make the new virtual drives visible to the kernel
+func (r *RaidController) rescan() {
+	hosts, err := filepath.Glob(filepath.Join(scsiHosts, "host*", "scan"))
+	if err != nil {
+		return
+	}
+	for _, h := range hosts {
+		err := os.WriteFile(h, []byte("- - -"), 0200) // nolint:gosec
+		if err != nil {
+			r.log.Warn("unable to rescan scsi host", "host", h, "error", err)
+		}
+	}
+	r.waitForBlockDevices()
+}
+
+// waitForBlockDevices waits until the kernel stopped adding or removing block devices after the rescan
+func (r *RaidController) waitForBlockDevices() {
+	start := time.Now()
+	count := func() int {
+		entries, _ := os.ReadDir(sysBlock)
+		return len(entries)
+	}
+	last, changed := count(), start
+	for time.Since(start) < settleTimeout {
+		time.Sleep(settleInterval)
+		n := count()
+		if n != last {
+			last, changed = n, time.Now()
+			continue
+		}
+		if time.Since(changed) >= settleQuiet {
+			break
+		}
+	}
+	r.log.Info("block devices settled after rescan", "devices", last, "took", time.Since(start))
+}
+
+func (r *RaidController) run(args ...string) (string, error) {
+	output, err := runCLI(r.cli, args...)
+	r.log.Debug("run", "command", r.cli, "args", args, "output", string(output), "error", err)
+	if err != nil {
+		return string(output), fmt.Errorf("%s %s failed output:%s %w", filepath.Base(r.cli), strings.Join(args, " "), output, err)
+	}
+	return string(output), nil
+}
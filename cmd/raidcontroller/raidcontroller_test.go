@@ -0,0 +1,273 @@
+package raidcontroller
+
+import (
+	"log/slog"
+	"reflect"
+	"testing"
+)
+
+func TestConfigFromTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		want    *Config
+		wantErr bool
+	}{
+		{
+			name: "no tags",
+			tags: []string{"foo=bar"},
+			want: &Config{Mode: ModeKeep},
+		},
+		{
+			name: "jbod",
+			tags: []string{ModeTag + "=jbod"},
+			want: &Config{Mode: ModeJBOD},
+		},
+		{
+			name: "raid",
+			tags: []string{ModeTag + "=raid", VirtualDrivesTag + "=raid1:2,RAID5:*"},
+			want: &Config{Mode: ModeRaid, VirtualDrives: []VirtualDriveSpec{{Type: "raid1", Drives: 2}, {Type: "raid5"}}},
+		},
+		{
+			name:    "raid without virtual drives",
+			tags:    []string{ModeTag + "=raid"},
+			wantErr: true,
+		},
+		{
+			name:    "remaining drives not last",
+			tags:    []string{ModeTag + "=raid", VirtualDrivesTag + "=raid5:*,raid1:2"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			tags:    []string{ModeTag + "=hba"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ConfigFromTags(tt.tags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConfigFromTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ConfigFromTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseControllers(t *testing.T) {
+	output := `{
+"Controllers":[{
+	"Command Status":{"CLI Version":"007.1017","Controller":0,"Status":"Success","Description":"None"},
+	"Response Data":{
+		"Basics":{"Controller":0,"Model":"AVAGO 3108 MegaRAID","Serial Number":"SV12345"},
+		"Version":{"Firmware Version":"4.680.00-8290"},
+		"VD LIST":[{"DG/VD":"0/0","TYPE":"RAID1","State":"Optl","Size":"1.818 TB","Name":""}],
+		"PD LIST":[
+			{"EID:Slt":"252:0","DID":8,"State":"Onln","DG":0,"Size":"1.818 TB","Intf":"SATA","Med":"HDD","Model":"ST2000NM0055"},
+			{"EID:Slt":"252:1","DID":9,"State":"Onln","DG":0,"Size":"1.818 TB","Intf":"SATA","Med":"HDD","Model":"ST2000NM0055"},
+			{"EID:Slt":"252:2","DID":10,"State":"UGood","DG":"-","Size":"1.818 TB","Intf":"SATA","Med":"HDD","Model":"ST2000NM0055"},
+			{"EID:Slt":"252:3","DID":11,"State":"UBad","DG":"-","Size":"1.818 TB","Intf":"SATA","Med":"HDD","Model":"ST2000NM0055"}
+		]
+	}
+}]}`
+	controllers, err := parseControllers(output)
+	if err != nil {
+		t.Fatalf("parseControllers() error = %v", err)
+	}
+	if len(controllers) != 1 {
+		t.Fatalf("parseControllers() got %d controllers, want 1", len(controllers))
+	}
+	c := controllers[0]
+	if c.Model != "AVAGO 3108 MegaRAID" || c.Firmware != "4.680.00-8290" || len(c.PhysicalDrives) != 4 || len(c.VirtualDrives) != 1 {
+		t.Errorf("parseControllers() = %+v", c)
+	}
+
+	commands, err := virtualDriveCommands("/c0", []VirtualDriveSpec{{Type: "raid1", Drives: 2}, {Type: "raid0"}}, c.PhysicalDrives)
+	if err != nil {
+		t.Fatalf("virtualDriveCommands() error = %v", err)
+	}
+	want := [][]string{
+		{"/c0", "add", "vd", "type=raid1", "drives=252:0,252:1"},
+		{"/c0", "add", "vd", "type=raid0", "drives=252:2"},
+	}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("virtualDriveCommands() = %v, want %v", commands, want)
+	}
+
+	jbod := []PhysicalDrive{
+		{EnclosureSlot: "252:0", State: "JBOD"},
+		{EnclosureSlot: "252:1", State: "UGood"},
+		{EnclosureSlot: "252:2", State: "JBOD"},
+	}
+	commands, err = virtualDriveCommands("/c0", []VirtualDriveSpec{{Type: "raid1", Drives: 2}, {Type: "raid0"}}, jbod)
+	if err != nil {
+		t.Fatalf("virtualDriveCommands() error = %v", err)
+	}
+	want = [][]string{
+		{"/c0/e252/s0", "set", "good", "force"},
+		{"/c0", "add", "vd", "type=raid1", "drives=252:0,252:1"},
+		{"/c0/e252/s2", "set", "good", "force"},
+		{"/c0", "add", "vd", "type=raid0", "drives=252:2"},
+	}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("virtualDriveCommands() = %v, want %v", commands, want)
+	}
+
+	_, err = virtualDriveCommands("/c0", []VirtualDriveSpec{{Type: "raid5", Drives: 4}}, c.PhysicalDrives)
+	if err == nil {
+		t.Errorf("virtualDriveCommands() expected error for too few drives")
+	}
+}
+
+func TestConfigured(t *testing.T) {
+	raid := []PhysicalDrive{
+		{EnclosureSlot: "252:0", State: "Onln"},
+		{EnclosureSlot: "252:1", State: "Onln"},
+		{EnclosureSlot: "252:2", State: "Onln"},
+		{EnclosureSlot: "252:3", State: "Onln"},
+		{EnclosureSlot: "252:4", State: "Onln"},
+		{EnclosureSlot: "252:5", State: "UBad"},
+	}
+	tests := []struct {
+		name       string
+		controller Controller
+		config     *Config
+		want       bool
+	}{
+		{
+			name:       "raid already configured",
+			controller: Controller{PhysicalDrives: raid, VirtualDrives: []VirtualDrive{{Type: "RAID1", Drives: 2}, {Type: "RAID5", Drives: 3}}},
+			config:     &Config{Mode: ModeRaid, VirtualDrives: []VirtualDriveSpec{{Type: "raid1", Drives: 2}, {Type: "raid5"}}},
+			want:       true,
+		},
+		{
+			name:       "raid type differs",
+			controller: Controller{PhysicalDrives: raid, VirtualDrives: []VirtualDrive{{Type: "RAID1", Drives: 2}, {Type: "RAID0", Drives: 3}}},
+			config:     &Config{Mode: ModeRaid, VirtualDrives: []VirtualDriveSpec{{Type: "raid1", Drives: 2}, {Type: "raid5"}}},
+		},
+		{
+			name:       "raid drives differ",
+			controller: Controller{PhysicalDrives: raid, VirtualDrives: []VirtualDrive{{Type: "RAID1", Drives: 2}, {Type: "RAID5", Drives: 3}}},
+			config:     &Config{Mode: ModeRaid, VirtualDrives: []VirtualDriveSpec{{Type: "raid1", Drives: 3}, {Type: "raid5"}}},
+		},
+		{
+			name:       "raid missing",
+			controller: Controller{PhysicalDrives: raid, VirtualDrives: []VirtualDrive{{Type: "RAID1", Drives: 2}}},
+			config:     &Config{Mode: ModeRaid, VirtualDrives: []VirtualDriveSpec{{Type: "raid1", Drives: 2}, {Type: "raid5"}}},
+		},
+		{
+			name:       "jbod already configured",
+			controller: Controller{PhysicalDrives: []PhysicalDrive{{EnclosureSlot: "252:0", State: "JBOD"}, {EnclosureSlot: "252:1", State: "UBad"}}},
+			config:     &Config{Mode: ModeJBOD},
+			want:       true,
+		},
+		{
+			name:       "jbod with unconfigured drive",
+			controller: Controller{PhysicalDrives: []PhysicalDrive{{EnclosureSlot: "252:0", State: "JBOD"}, {EnclosureSlot: "252:1", State: "UGood"}}},
+			config:     &Config{Mode: ModeJBOD},
+		},
+		{
+			name:       "jbod with virtual drive",
+			controller: Controller{PhysicalDrives: raid, VirtualDrives: []VirtualDrive{{Type: "RAID1", Drives: 2}}},
+			config:     &Config{Mode: ModeJBOD},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := configured(tt.controller, tt.config); got != tt.want {
+				t.Errorf("configured() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	inventory := `{
+"Controllers":[{
+	"Command Status":{"Controller":0,"Status":"Success","Description":"None"},
+	"Response Data":{
+		"Basics":{"Controller":0,"Model":"AVAGO 3108 MegaRAID","Serial Number":"SV12345"},
+		"Version":{"Firmware Version":"4.680.00-8290"},
+		"VD LIST":[{"DG/VD":"0/0","TYPE":"RAID1","State":"Optl","Size":"1.818 TB","Name":""}],
+		"PD LIST":[
+			{"EID:Slt":"252:0","State":"Onln","DG":0,"Size":"1.818 TB","Intf":"SATA","Med":"HDD","Model":"ST2000NM0055"},
+			{"EID:Slt":"252:1","State":"Onln","DG":0,"Size":"1.818 TB","Intf":"SATA","Med":"HDD","Model":"ST2000NM0055"},
+			{"EID:Slt":"252:2","State":"JBOD","DG":"-","Size":"1.818 TB","Intf":"SATA","Med":"HDD","Model":"ST2000NM0055"}
+		]
+	}
+}]}`
+	tests := []struct {
+		name   string
+		config *Config
+		want   [][]string
+	}{
+		{
+			name:   "already configured",
+			config: &Config{Mode: ModeRaid, VirtualDrives: []VirtualDriveSpec{{Type: "raid1", Drives: 2}}},
+			want: [][]string{
+				{"/call", "show", "all", "J"},
+			},
+		},
+		{
+			name:   "keep",
+			config: &Config{Mode: ModeKeep},
+			want: [][]string{
+				{"/call", "show", "all", "J"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var executed [][]string
+			original := runCLI
+			defer func() { runCLI = original }()
+			runCLI = func(cli string, args ...string) ([]byte, error) {
+				executed = append(executed, args)
+				return []byte(inventory), nil
+			}
+
+			r := &RaidController{log: slog.Default(), cli: "storcli64"}
+			err := r.Configure(tt.config)
+			if err != nil {
+				t.Fatalf("Configure() error = %v", err)
+			}
+			if !reflect.DeepEqual(executed, tt.want) {
+				t.Errorf("Configure() executed %v, want %v", executed, tt.want)
+			}
+		})
+	}
+
+	// drives which are already jbod are not set again
+	var executed [][]string
+	original := runCLI
+	defer func() { runCLI = original }()
+	runCLI = func(cli string, args ...string) ([]byte, error) {
+		executed = append(executed, args)
+		return nil, nil
+	}
+	controllers, err := parseControllers(inventory)
+	if err != nil {
+		t.Fatalf("parseControllers() error = %v", err)
+	}
+	if controllers[0].VirtualDrives[0].Drives != 2 {
+		t.Errorf("parseControllers() virtual drive has %d drives, want 2", controllers[0].VirtualDrives[0].Drives)
+	}
+	r := &RaidController{log: slog.Default(), cli: "storcli64"}
+	err = r.configure(controllers[0], &Config{Mode: ModeJBOD})
+	if err != nil {
+		t.Fatalf("configure() error = %v", err)
+	}
+	want := [][]string{
+		{"/c0/fall", "delete"},
+		{"/c0/vall", "del", "force"},
+		{"/c0", "set", "jbod=on"},
+		{"/c0/e252/s0", "set", "jbod"},
+		{"/c0/e252/s1", "set", "jbod"},
+	}
+	if !reflect.DeepEqual(executed, want) {
+		t.Errorf("configure() executed %v, want %v", executed, want)
+	}
+}
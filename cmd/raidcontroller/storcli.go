@@ -0,0 +1,92 @@
+package raidcontroller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// storcliOutput is the json output of storcli /call show all J, perccli uses the same format
+type storcliOutput struct {
+	Controllers []struct {
+		CommandStatus struct {
+			Controller  int    `json:"Controller"`
+			Status      string `json:"Status"`
+			Description string `json:"Description"`
+		} `json:"Command Status"`
+		ResponseData struct {
+			Basics struct {
+				Controller int    `json:"Controller"`
+				Model      string `json:"Model"`
+				Serial     string `json:"Serial Number"`
+			} `json:"Basics"`
+			Version struct {
+				Firmware string `json:"Firmware Version"`
+			} `json:"Version"`
+			PhysicalDrives []struct {
+				EnclosureSlot string `json:"EID:Slt"`
+				State         string `json:"State"`
+				// DriveGroup is a number or - if the drive is not part of a drive group
+				DriveGroup any    `json:"DG"`
+				Size       string `json:"Size"`
+				Interface  string `json:"Intf"`
+				Medium     string `json:"Med"`
+				Model      string `json:"Model"`
+			} `json:"PD LIST"`
+			VirtualDrives []struct {
+				// DriveGroupVirtualDrive like 0/0
+				DriveGroupVirtualDrive string `json:"DG/VD"`
+				Name                   string `json:"Name"`
+				Type                   string `json:"TYPE"`
+				State                  string `json:"State"`
+				Size                   string `json:"Size"`
+			} `json:"VD LIST"`
+		} `json:"Response Data"`
+	} `json:"Controllers"`
+}
+
+func parseControllers(output string) ([]Controller, error) {
+	var out storcliOutput
+	err := json.Unmarshal([]byte(output), &out)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse controller output %w", err)
+	}
+
+	var controllers []Controller
+	for _, c := range out.Controllers {
+		if c.CommandStatus.Status != "Success" {
+			return nil, fmt.Errorf("controller %d reported %s: %s", c.CommandStatus.Controller, c.CommandStatus.Status, c.CommandStatus.Description)
+		}
+		data := c.ResponseData
+		controller := Controller{
+			Index:    c.CommandStatus.Controller,
+			Model:    data.Basics.Model,
+			Serial:   data.Basics.Serial,
+			Firmware: data.Version.Firmware,
+		}
+		groups := map[string]int{}
+		for _, pd := range data.PhysicalDrives {
+			groups[fmt.Sprint(pd.DriveGroup)]++
+			controller.PhysicalDrives = append(controller.PhysicalDrives, PhysicalDrive{
+				EnclosureSlot: pd.EnclosureSlot,
+				State:         pd.State,
+				Size:          pd.Size,
+				Interface:     pd.Interface,
+				Medium:        pd.Medium,
+				Model:         pd.Model,
+			})
+		}
+		for _, vd := range data.VirtualDrives {
+			group, _, _ := strings.Cut(vd.DriveGroupVirtualDrive, "/")
+			controller.VirtualDrives = append(controller.VirtualDrives, VirtualDrive{
+				Name:   vd.Name,
+				Type:   vd.Type,
+				State:  vd.State,
+				Size:   vd.Size,
+				Drives: groups[group],
+			})
+		}
+		controllers = append(controllers, controller)
+	}
+	return controllers, nil
+}
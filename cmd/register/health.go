@@ -0,0 +1,288 @@
+package register
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/jaypipes/ghw"
+	"github.com/metal-stack/metal-hammer/cmd/event"
+	"github.com/metal-stack/metal-hammer/pkg/os/command"
+)
+
+// HealthPolicy defines what happens if a disk exceeds a health threshold
+type HealthPolicy string
+
+const (
+	// HealthPolicyIgnore does not collect any disk health
+	HealthPolicyIgnore = HealthPolicy("ignore")
+	// HealthPolicyWarn reports failing disks but registers the machine
+	HealthPolicyWarn = HealthPolicy("warn")
+	// HealthPolicyRefuse refuses the registration of a machine with failing disks
+	HealthPolicyRefuse = HealthPolicy("refuse")
+)
+
+// smartctl exit status bits which indicate that the output is not usable
+const smartctlFatal = 0x3
+
+// ATA smart attribute ids
+const (
+	ataReallocatedSectors = 5
+	ataPendingSectors     = 197
+)
+
+// HealthThresholds are the maximum values a healthy disk may report
+type HealthThresholds struct {
+	Policy             HealthPolicy
+	ReallocatedSectors uint64
+	PendingSectors     uint64
+	GrownDefects       uint64
+	PercentageUsed     uint64
+	MediaErrors        uint64
+}
+
+// DefaultHealthThresholds only warn about disks which are obviously failing
+var DefaultHealthThresholds = HealthThresholds{
+	Policy:             HealthPolicyWarn,
+	ReallocatedSectors: 10,
+	PendingSectors:     0,
+	GrownDefects:       10,
+	PercentageUsed:     100,
+	MediaErrors:        0,
+}
+
+// ParseHealthThresholds overwrites the defaults with a comma separated list of thresholds,
+// e.g. reallocated:10,pending:0,defects:10,used:90,mediaerrors:0
+func ParseHealthThresholds(policy, thresholds string) (HealthThresholds, error) {
+	t := DefaultHealthThresholds
+	switch HealthPolicy(policy) {
+	case "":
+	case HealthPolicyIgnore, HealthPolicyWarn, HealthPolicyRefuse:
+		t.Policy = HealthPolicy(policy)
+	default:
+		return t, fmt.Errorf("invalid disk health policy %q, must be one of %s, %s or %s", policy, HealthPolicyIgnore, HealthPolicyWarn, HealthPolicyRefuse)
+	}
+	for _, threshold := range strings.Split(thresholds, ",") {
+		threshold = strings.TrimSpace(threshold)
+		if threshold == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(threshold, ":")
+		if !ok {
+			return t, fmt.Errorf("invalid disk health threshold %q, must be name:value", threshold)
+		}
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return t, fmt.Errorf("invalid disk health threshold %q %w", threshold, err)
+		}
+		switch strings.ToLower(name) {
+		case "reallocated":
+			t.ReallocatedSectors = v
+		case "pending":
+			t.PendingSectors = v
+		case "defects":
+			t.GrownDefects = v
+		case "used":
+			t.PercentageUsed = v
+		case "mediaerrors":
+			t.MediaErrors = v
+		default:
+			return t, fmt.Errorf("invalid disk health threshold %q, name must be one of reallocated, pending, defects, used or mediaerrors", threshold)
+		}
+	}
+	return t, nil
+}
+
+// DiskHealth is the health reported by smartctl or the nvme smart-log
+type DiskHealth struct {
+	Device       string `json:"device"`
+	Serial       string `json:"serial"`
+	Passed       bool   `json:"passed"`
+	PowerOnHours uint64 `json:"power_on_hours"`
+	// SATA
+	ReallocatedSectors uint64 `json:"reallocated_sectors,omitempty"`
+	PendingSectors     uint64 `json:"pending_sectors,omitempty"`
+	// SAS
+	GrownDefects uint64 `json:"grown_defects,omitempty"`
+	// NVMe
+	PercentageUsed  uint64 `json:"percentage_used,omitempty"`
+	MediaErrors     uint64 `json:"media_errors,omitempty"`
+	CriticalWarning uint64 `json:"critical_warning,omitempty"`
+}
+
+func (h DiskHealth) String() string {
+	j, err := json.Marshal(h)
+	if err != nil {
+		return h.Device
+	}
+	return string(j)
+}
+
+// Failures returns the reasons why the disk is considered failing, empty if the disk is healthy
+func (h DiskHealth) Failures(t HealthThresholds) []string {
+	var failures []string
+	if !h.Passed && h.CriticalWarning == 0 {
+		failures = append(failures, "smart overall health check failed")
+	}
+	if h.CriticalWarning != 0 {
+		failures = append(failures, fmt.Sprintf("critical warning 0x%x", h.CriticalWarning))
+	}
+	check := func(name string, value, limit uint64) {
+		if value > limit {
+			failures = append(failures, fmt.Sprintf("%s %d exceeds %d", name, value, limit))
+		}
+	}
+	check("reallocated sectors", h.ReallocatedSectors, t.ReallocatedSectors)
+	check("pending sectors", h.PendingSectors, t.PendingSectors)
+	check("grown defects", h.GrownDefects, t.GrownDefects)
+	check("percentage used", h.PercentageUsed, t.PercentageUsed)
+	check("media errors", h.MediaErrors, t.MediaErrors)
+	return failures
+}
+
+// smartctlOutput is the part of smartctl --json --all which is used
+type smartctlOutput struct {
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	PowerOnTime struct {
+		Hours uint64 `json:"hours"`
+	} `json:"power_on_time"`
+	ATASmartAttributes struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value uint64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	SCSIGrownDefectList uint64 `json:"scsi_grown_defect_list"`
+}
+
+// nvmeSmartLog is the part of nvme smart-log --output-format=json which is used
+type nvmeSmartLog struct {
+	CriticalWarning uint64 `json:"critical_warning"`
+	PercentUsed     uint64 `json:"percent_used"`
+	MediaErrors     uint64 `json:"media_errors"`
+	PowerOnHours    uint64 `json:"power_on_hours"`
+}
+
+// diskHealth reads the smart-log of nvme disks and the smart attributes of all other disks
+func diskHealth(disk *ghw.Disk) (*DiskHealth, error) {
+	device := fmt.Sprintf("/dev/%s", disk.Name)
+	if strings.HasPrefix(disk.Name, "nvme") {
+		out, err := healthCommand(command.NVME, "smart-log", device, "--output-format=json")
+		if err != nil {
+			return nil, err
+		}
+		return parseNVMeSmartLog(device, disk.SerialNumber, out)
+	}
+	out, err := healthCommand(command.SmartCtl, "--json", "--all", device)
+	if err != nil {
+		return nil, err
+	}
+	return parseSmartctl(device, disk.SerialNumber, out)
+}
+
+func healthCommand(name string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to locate program:%s in path %w", name, err)
+	}
+	out, err := exec.Command(path, args...).Output()
+	// smartctl returns a bitmask as exit status, only the lowest bits indicate that the output is not usable
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && name == command.SmartCtl && exitErr.ExitCode()&smartctlFatal == 0 {
+		return out, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute %s %v %w", name, args, err)
+	}
+	return out, nil
+}
+
+func parseNVMeSmartLog(device, serial string, out []byte) (*DiskHealth, error) {
+	var log nvmeSmartLog
+	err := json.Unmarshal(out, &log)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse smart-log of %s %w", device, err)
+	}
+	return &DiskHealth{
+		Device:          device,
+		Serial:          serial,
+		Passed:          log.CriticalWarning == 0,
+		PowerOnHours:    log.PowerOnHours,
+		PercentageUsed:  log.PercentUsed,
+		MediaErrors:     log.MediaErrors,
+		CriticalWarning: log.CriticalWarning,
+	}, nil
+}
+
+func parseSmartctl(device, serial string, out []byte) (*DiskHealth, error) {
+	var s smartctlOutput
+	err := json.Unmarshal(out, &s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse smartctl output of %s %w", device, err)
+	}
+	if s.SmartStatus == nil {
+		return nil, fmt.Errorf("%s does not support smart", device)
+	}
+	h := &DiskHealth{
+		Device:       device,
+		Serial:       serial,
+		Passed:       s.SmartStatus.Passed,
+		PowerOnHours: s.PowerOnTime.Hours,
+		GrownDefects: s.SCSIGrownDefectList,
+	}
+	for _, a := range s.ATASmartAttributes.Table {
+		switch a.ID {
+		case ataReallocatedSectors:
+			h.ReallocatedSectors = a.Raw.Value
+		case ataPendingSectors:
+			h.PendingSectors = a.Raw.Value
+		}
+	}
+	return h, nil
+}
+
+// checkDiskHealth reports the health of every disk as event to metal-api where it is stored in the event log of the machine.
+// The health is not part of the register request, the block devices of the boot service only carry the name and the size
+// and the proto of metal-api can not be extended from here. With the refuse policy an error is returned for failing disks,
+// it aborts the registration before the register request is sent and the provisioning ends with a crashed event.
+func (r *Register) checkDiskHealth(disks []*ghw.Disk) error {
+	if r.health.Policy == HealthPolicyIgnore {
+		return nil
+	}
+	var failing []string
+	for _, disk := range disks {
+		if disk.DriveType != ghw.DriveTypeHDD && disk.DriveType != ghw.DriveTypeSSD {
+			continue
+		}
+		health, err := diskHealth(disk)
+		if err != nil {
+			r.log.Warn("unable to read disk health", "disk", disk.Name, "error", err)
+			continue
+		}
+		r.log.Info("disk health", "health", health)
+		failures := health.Failures(r.health)
+		if len(failures) == 0 {
+			r.emitter.Emit(event.ProvisioningEventRegistering, "disk health "+health.String())
+			continue
+		}
+		r.log.Error("disk is failing", "disk", health.Device, "serial", health.Serial, "failures", failures)
+		r.emitter.Emit(event.ProvisioningEventRegistering, fmt.Sprintf("disk %s serial:%s is failing: %s health %s", health.Device, health.Serial, strings.Join(failures, ", "), health))
+		failing = append(failing, health.Device)
+	}
+	return r.health.refuseRegistration(failing)
+}
+
+// refuseRegistration returns an error if the policy does not allow to register a machine with the failing disks
+func (t HealthThresholds) refuseRegistration(failing []string) error {
+	if len(failing) > 0 && t.Policy == HealthPolicyRefuse {
+		return fmt.Errorf("refuse registration because of failing disks %v", failing)
+	}
+	return nil
+}
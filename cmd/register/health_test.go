@@ -0,0 +1,171 @@
+package register
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSmartctl(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    *DiskHealth
+		wantErr bool
+	}{
+		{
+			name: "sata",
+			output: `{"smart_status":{"passed":true},"power_on_time":{"hours":21034},
+				"ata_smart_attributes":{"table":[{"id":5,"name":"Reallocated_Sector_Ct","raw":{"value":12}},{"id":9,"name":"Power_On_Hours","raw":{"value":21034}},{"id":197,"name":"Current_Pending_Sector","raw":{"value":1}}]}}`,
+			want: &DiskHealth{Device: "/dev/sda", Serial: "S1", Passed: true, PowerOnHours: 21034, ReallocatedSectors: 12, PendingSectors: 1},
+		},
+		{
+			name:   "sas",
+			output: `{"smart_status":{"passed":false},"power_on_time":{"hours":100},"scsi_grown_defect_list":3}`,
+			want:   &DiskHealth{Device: "/dev/sda", Serial: "S1", Passed: false, PowerOnHours: 100, GrownDefects: 3},
+		},
+		{
+			name:    "no smart support",
+			output:  `{"device":{"name":"/dev/sda"}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSmartctl("/dev/sda", "S1", []byte(tt.output))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSmartctl() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSmartctl() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNVMeSmartLog(t *testing.T) {
+	output := `{"critical_warning":4,"temperature":310,"avail_spare":100,"spare_thresh":10,"percent_used":3,"media_errors":0,"power_on_hours":8760}`
+	want := &DiskHealth{Device: "/dev/nvme0n1", Serial: "N1", Passed: false, PowerOnHours: 8760, PercentageUsed: 3, CriticalWarning: 4}
+	got, err := parseNVMeSmartLog("/dev/nvme0n1", "N1", []byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseNVMeSmartLog() = %v, want %v", got, want)
+	}
+}
+
+func TestFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		health DiskHealth
+		want   []string
+	}{
+		{
+			name:   "healthy",
+			health: DiskHealth{Passed: true, PowerOnHours: 40000, ReallocatedSectors: 10},
+		},
+		{
+			name:   "sata failing",
+			health: DiskHealth{Passed: true, ReallocatedSectors: 11, PendingSectors: 2},
+			want:   []string{"reallocated sectors 11 exceeds 10", "pending sectors 2 exceeds 0"},
+		},
+		{
+			name:   "nvme critical warning",
+			health: DiskHealth{Passed: false, CriticalWarning: 4, PercentageUsed: 101},
+			want:   []string{"critical warning 0x4", "percentage used 101 exceeds 100"},
+		},
+		{
+			name:   "smart failed",
+			health: DiskHealth{Passed: false},
+			want:   []string{"smart overall health check failed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.health.Failures(DefaultHealthThresholds); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Failures() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHealthThresholds(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		thresholds string
+		want       HealthThresholds
+		wantErr    bool
+	}{
+		{
+			name: "defaults",
+			want: DefaultHealthThresholds,
+		},
+		{
+			name:       "refuse with thresholds",
+			policy:     "refuse",
+			thresholds: "reallocated:0, used:90",
+			want:       HealthThresholds{Policy: HealthPolicyRefuse, ReallocatedSectors: 0, PendingSectors: 0, GrownDefects: 10, PercentageUsed: 90, MediaErrors: 0},
+		},
+		{
+			name:    "invalid policy",
+			policy:  "reboot",
+			wantErr: true,
+		},
+		{
+			name:       "invalid threshold",
+			thresholds: "temperature:70",
+			wantErr:    true,
+		},
+		{
+			name:       "invalid value",
+			thresholds: "used:ninety",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHealthThresholds(tt.policy, tt.thresholds)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHealthThresholds() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseHealthThresholds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefuseRegistration(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  HealthPolicy
+		failing []string
+		wantErr bool
+	}{
+		{
+			name:    "refuse failing disks",
+			policy:  HealthPolicyRefuse,
+			failing: []string{"/dev/sda"},
+			wantErr: true,
+		},
+		{
+			name:   "refuse without failing disks",
+			policy: HealthPolicyRefuse,
+		},
+		{
+			name:    "warn about failing disks",
+			policy:  HealthPolicyWarn,
+			failing: []string{"/dev/sda"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thresholds := HealthThresholds{Policy: tt.policy}
+			err := thresholds.refuseRegistration(tt.failing)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("refuseRegistration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package register
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/metal-stack/metal-hammer/cmd/event"
+)
+
+const (
+	// smbiosTable contains all smbios structures as exported by the kernel
+	smbiosTable = "/sys/firmware/dmi/tables/DMI"
+
+	smbiosTypeMemoryDevice = 17
+	smbiosTypeEndOfTable   = 127
+
+	gib = 1024 * 1024 * 1024
+)
+
+// smbiosMemoryTypes of the memory device structure, only the types found in servers are named
+var smbiosMemoryTypes = map[byte]string{
+	0x12: "DDR",
+	0x13: "DDR2",
+	0x14: "DDR2 FB-DIMM",
+	0x18: "DDR3",
+	0x1A: "DDR4",
+	0x1E: "LPDDR4",
+	0x1F: "NVDIMM",
+	0x20: "HBM",
+	0x21: "HBM2",
+	0x22: "DDR5",
+	0x23: "LPDDR5",
+	0x24: "HBM3",
+}
+
+// DIMM is a memory device from smbios type 17
+type DIMM struct {
+	Locator      string `json:"locator"`
+	Bank         string `json:"bank"`
+	SizeBytes    uint64 `json:"size"`
+	Type         string `json:"type"`
+	SpeedMTs     uint32 `json:"speed"`
+	Manufacturer string `json:"manufacturer"`
+	SerialNumber string `json:"serial"`
+	PartNumber   string `json:"partnumber"`
+}
+
+// Populated is false for empty slots
+func (d DIMM) Populated() bool {
+	return d.SizeBytes > 0
+}
+
+func (d DIMM) String() string {
+	if !d.Populated() {
+		return fmt.Sprintf("%s: empty", d.Locator)
+	}
+	return fmt.Sprintf("%s: %d GiB %s %d MT/s manufacturer:%s serial:%s partnumber:%s", d.Locator, d.SizeBytes/gib, d.Type, d.SpeedMTs, d.Manufacturer, d.SerialNumber, d.PartNumber)
+}
+
+// smbiosStructure is a single structure of the smbios table with its formatted area and strings
+type smbiosStructure struct {
+	formatted []byte
+	strings   []string
+}
+
+// byte returns the byte at offset of the formatted area, zero if the structure is too short
+func (s smbiosStructure) byte(offset int) byte {
+	if offset >= len(s.formatted) {
+		return 0
+	}
+	return s.formatted[offset]
+}
+
+func (s smbiosStructure) word(offset int) uint16 {
+	if offset+2 > len(s.formatted) {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(s.formatted[offset:])
+}
+
+func (s smbiosStructure) dword(offset int) uint32 {
+	if offset+4 > len(s.formatted) {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(s.formatted[offset:])
+}
+
+// string returns the string referenced at offset, strings are numbered from one
+func (s smbiosStructure) string(offset int) string {
+	i := int(s.byte(offset))
+	if i == 0 || i > len(s.strings) {
+		return ""
+	}
+	return strings.TrimSpace(s.strings[i-1])
+}
+
+// parseSMBIOS returns all structures of the given type
+func parseSMBIOS(table []byte, structureType byte) ([]smbiosStructure, error) {
+	var result []smbiosStructure
+	for len(table) >= 4 {
+		t := table[0]
+		length := int(table[1])
+		if length < 4 || length > len(table) {
+			return nil, fmt.Errorf("invalid smbios structure type %d with length %d", t, length)
+		}
+		formatted := table[:length]
+		// the strings follow the formatted area and are terminated by two zero bytes
+		end := bytes.Index(table[length:], []byte{0, 0})
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated strings of smbios structure type %d", t)
+		}
+		var ss []string
+		for _, s := range bytes.Split(table[length:length+end], []byte{0}) {
+			if len(s) > 0 {
+				ss = append(ss, string(s))
+			}
+		}
+		if t == structureType {
+			result = append(result, smbiosStructure{formatted: formatted, strings: ss})
+		}
+		if t == smbiosTypeEndOfTable {
+			break
+		}
+		table = table[length+end+2:]
+	}
+	return result, nil
+}
+
+// parseDIMMs reads all memory devices, see DSP0134 7.18 Memory Device (Type 17)
+func parseDIMMs(table []byte) ([]DIMM, error) {
+	structures, err := parseSMBIOS(table, smbiosTypeMemoryDevice)
+	if err != nil {
+		return nil, err
+	}
+	var dimms []DIMM
+	for _, s := range structures {
+		d := DIMM{
+			Locator:      s.string(0x10),
+			Bank:         s.string(0x11),
+			Manufacturer: s.string(0x17),
+			SerialNumber: s.string(0x18),
+			PartNumber:   s.string(0x1A),
+			SpeedMTs:     uint32(s.word(0x15)),
+		}
+		t, ok := smbiosMemoryTypes[s.byte(0x12)]
+		if !ok {
+			t = fmt.Sprintf("unknown(0x%x)", s.byte(0x12))
+		}
+		d.Type = t
+
+		size := s.word(0x0C)
+		switch {
+		case size == 0 || size == 0xFFFF:
+			// no module installed or size unknown
+		case size == 0x7FFF:
+			d.SizeBytes = uint64(s.dword(0x1C)&0x7FFFFFFF) * 1024 * 1024
+		case size&0x8000 != 0:
+			d.SizeBytes = uint64(size&0x7FFF) * 1024
+		default:
+			d.SizeBytes = uint64(size) * 1024 * 1024
+		}
+		if d.SpeedMTs == 0xFFFF {
+			d.SpeedMTs = s.dword(0x54)
+		}
+		if !d.Populated() {
+			d = DIMM{Locator: d.Locator, Bank: d.Bank}
+		}
+		dimms = append(dimms, d)
+	}
+	return dimms, nil
+}
+
+// dimmMismatches returns differences between the populated dimms, mixed dimms usually
+// indicate a wrong replacement and force the memory controller to the lowest common speed
+func dimmMismatches(dimms []DIMM) []string {
+	var (
+		mismatches []string
+		first      *DIMM
+	)
+	for i := range dimms {
+		d := dimms[i]
+		if !d.Populated() {
+			continue
+		}
+		if first == nil {
+			first = &d
+			continue
+		}
+		if d.SizeBytes != first.SizeBytes {
+			mismatches = append(mismatches, fmt.Sprintf("%s has %d GiB, %s has %d GiB", d.Locator, d.SizeBytes/gib, first.Locator, first.SizeBytes/gib))
+		}
+		if d.Type != first.Type {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %s, %s is %s", d.Locator, d.Type, first.Locator, first.Type))
+		}
+		if d.SpeedMTs != first.SpeedMTs {
+			mismatches = append(mismatches, fmt.Sprintf("%s runs with %d MT/s, %s with %d MT/s", d.Locator, d.SpeedMTs, first.Locator, first.SpeedMTs))
+		}
+		if d.PartNumber != first.PartNumber {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %s, %s is %s", d.Locator, d.PartNumber, first.Locator, first.PartNumber))
+		}
+	}
+	return mismatches
+}
+
+// reportDIMMs sends every populated dimm, the number of empty slots and mismatched dimms as event to metal-api
+// where they are stored in the event log of the machine. The register request only carries the total memory,
+// the hardware of the boot service has no field for dimms and the proto of metal-api can not be extended from here.
+// Mismatches are only reported, they do not prevent the registration.
+func (r *Register) reportDIMMs() {
+	table, err := os.ReadFile(smbiosTable)
+	if err != nil {
+		r.log.Warn("unable to read smbios table, no dimm inventory", "error", err)
+		return
+	}
+	dimms, err := parseDIMMs(table)
+	if err != nil {
+		r.log.Warn("unable to parse smbios table, no dimm inventory", "error", err)
+		return
+	}
+
+	var (
+		populated int
+		total     uint64
+	)
+	for _, d := range dimms {
+		r.log.Info("dimm", "dimm", d.String())
+		if !d.Populated() {
+			continue
+		}
+		populated++
+		total += d.SizeBytes
+		r.emitter.Emit(event.ProvisioningEventRegistering, "dimm "+d.String())
+	}
+	r.emitter.Emit(event.ProvisioningEventRegistering, fmt.Sprintf("memory inventory: %d of %d slots populated with %d GiB", populated, len(dimms), total/gib))
+	for _, m := range dimmMismatches(dimms) {
+		r.log.Warn("dimm mismatch", "mismatch", m)
+		r.emitter.Emit(event.ProvisioningEventRegistering, "dimm mismatch: "+m)
+	}
+}
@@ -0,0 +1,84 @@
+package register
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// memoryDevice builds a smbios type 17 structure of SMBIOS 3.2 with the strings
+// locator, bank, manufacturer, serial and part number
+func memoryDevice(size uint16, extendedSize uint32, memoryType byte, speed uint16, strs ...string) []byte {
+	b := make([]byte, 0x5C)
+	b[0] = smbiosTypeMemoryDevice
+	b[1] = byte(len(b))
+	binary.LittleEndian.PutUint16(b[0x0C:], size)
+	b[0x10] = 1
+	b[0x11] = 2
+	b[0x12] = memoryType
+	binary.LittleEndian.PutUint16(b[0x15:], speed)
+	b[0x17] = 3
+	b[0x18] = 4
+	b[0x1A] = 5
+	binary.LittleEndian.PutUint32(b[0x1C:], extendedSize)
+	for _, s := range strs {
+		b = append(b, []byte(s)...)
+		b = append(b, 0)
+	}
+	return append(b, 0)
+}
+
+func TestParseDIMMs(t *testing.T) {
+	var table []byte
+	// bios information which must be skipped
+	table = append(table, 0, 4, 0, 0, 'A', 'M', 'I', 0, 0)
+	table = append(table, memoryDevice(16384, 0, 0x1A, 3200, "P1-DIMMA1", "P0_Node0_Channel0_Dimm0", "Samsung", "S1", "M393A4K40DB3-CWE")...)
+	table = append(table, memoryDevice(0, 0, 0x02, 0, "P1-DIMMA2", "P0_Node0_Channel0_Dimm1", "NO DIMM", "NO DIMM", "NO DIMM")...)
+	table = append(table, memoryDevice(0x7FFF, 65536, 0x22, 4800, "DIMM_B1", "BANK 1", "Micron", "S2", "MTC40F2046S1RC48BA1")...)
+	// end of table
+	table = append(table, smbiosTypeEndOfTable, 4, 0, 0, 0, 0)
+
+	want := []DIMM{
+		{Locator: "P1-DIMMA1", Bank: "P0_Node0_Channel0_Dimm0", SizeBytes: 16 * gib, Type: "DDR4", SpeedMTs: 3200, Manufacturer: "Samsung", SerialNumber: "S1", PartNumber: "M393A4K40DB3-CWE"},
+		{Locator: "P1-DIMMA2", Bank: "P0_Node0_Channel0_Dimm1"},
+		{Locator: "DIMM_B1", Bank: "BANK 1", SizeBytes: 64 * gib, Type: "DDR5", SpeedMTs: 4800, Manufacturer: "Micron", SerialNumber: "S2", PartNumber: "MTC40F2046S1RC48BA1"},
+	}
+	got, err := parseDIMMs(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDIMMs() = %v, want %v", got, want)
+	}
+
+	_, err = parseDIMMs([]byte{smbiosTypeMemoryDevice, 0x5C, 0, 0})
+	if err == nil {
+		t.Errorf("parseDIMMs() expected error for truncated table")
+	}
+}
+
+func TestDIMMMismatches(t *testing.T) {
+	a := DIMM{Locator: "A1", SizeBytes: 32 * gib, Type: "DDR4", SpeedMTs: 3200, PartNumber: "P1"}
+	tests := []struct {
+		name  string
+		dimms []DIMM
+		want  []string
+	}{
+		{
+			name:  "identical with empty slot",
+			dimms: []DIMM{a, {Locator: "A2"}, {Locator: "B1", SizeBytes: 32 * gib, Type: "DDR4", SpeedMTs: 3200, PartNumber: "P1"}},
+		},
+		{
+			name:  "different size and part number",
+			dimms: []DIMM{a, {Locator: "B1", SizeBytes: 16 * gib, Type: "DDR4", SpeedMTs: 3200, PartNumber: "P2"}},
+			want:  []string{"B1 has 16 GiB, A1 has 32 GiB", "B1 is P2, A1 is P1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dimmMismatches(tt.dimms); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dimmMismatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package register
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/metal-stack/metal-hammer/cmd/event"
+	"github.com/metal-stack/metal-hammer/pkg/os"
+	"github.com/u-root/u-root/pkg/pci"
+)
+
+// pci device kinds
+const (
+	PCIKindGPU         = "gpu"
+	PCIKindAccelerator = "accelerator"
+	PCIKindFPGA        = "fpga"
+	PCIKindDPU         = "dpu"
+)
+
+var (
+	// pciKinds can be assigned by a matcher
+	pciKinds = []string{PCIKindGPU, PCIKindAccelerator, PCIKindFPGA, PCIKindDPU}
+	// pciMatcherAttributes can be used in a matcher
+	pciMatcherAttributes = []string{"vendor", "device", "class", "name"}
+	// pciDevices contains a directory per pci function with the attributes not read by the pci package
+	pciDevices = "/sys/bus/pci/devices"
+)
+
+// PCIMatcher assigns a kind to all pci devices which match all patterns of the matcher.
+// Patterns are shell patterns, vendor, device and class are matched against the hex ids like 10de or 0302,
+// name against the vendor and device name.
+type PCIMatcher struct {
+	Kind     string
+	Patterns map[string]string
+}
+
+func (m PCIMatcher) String() string {
+	var patterns []string
+	for _, a := range pciMatcherAttributes {
+		if p, ok := m.Patterns[a]; ok {
+			patterns = append(patterns, a+"="+p)
+		}
+	}
+	return m.Kind + ":" + strings.Join(patterns, "+")
+}
+
+// PCIMatchers are evaluated in order, the first matching matcher determines the kind of a device
+type PCIMatchers []PCIMatcher
+
+// DefaultPCIMatchers classify the common gpus, accelerators, fpgas and dpus,
+// the onboard vga of the bmc is a display controller as well and must not be counted as gpu.
+var DefaultPCIMatchers = PCIMatchers{
+	// nvidia bluefield soc and amd pensando management interfaces, the network functions of a dpu are ordinary nics
+	{Kind: PCIKindDPU, Patterns: map[string]string{"vendor": "15b3", "device": "c2d[2-6]"}},
+	{Kind: PCIKindDPU, Patterns: map[string]string{"vendor": "1dd8", "device": "1004"}},
+	// xilinx and altera
+	{Kind: PCIKindFPGA, Patterns: map[string]string{"vendor": "10ee"}},
+	{Kind: PCIKindFPGA, Patterns: map[string]string{"vendor": "1172"}},
+	// nvidia, amd and intel display controllers: vga, 3d and other
+	{Kind: PCIKindGPU, Patterns: map[string]string{"vendor": "10de", "class": "03*"}},
+	{Kind: PCIKindGPU, Patterns: map[string]string{"vendor": "1002", "class": "03*"}},
+	{Kind: PCIKindGPU, Patterns: map[string]string{"vendor": "8086", "class": "03*"}},
+	// processing accelerators
+	{Kind: PCIKindAccelerator, Patterns: map[string]string{"class": "12*"}},
+}
+
+// ParsePCIMatchers parses a comma separated list of matchers in the form kind:attribute=pattern+attribute=pattern,
+// e.g. gpu:vendor=10de+class=03*,accelerator:vendor=1e52
+func ParsePCIMatchers(matchers string) (PCIMatchers, error) {
+	var result PCIMatchers
+	for _, matcher := range strings.Split(matchers, ",") {
+		matcher = strings.TrimSpace(matcher)
+		if matcher == "" {
+			continue
+		}
+		kind, patterns, ok := strings.Cut(matcher, ":")
+		if !ok || patterns == "" {
+			return nil, fmt.Errorf("invalid pci matcher %q, must be kind:attribute=pattern", matcher)
+		}
+		if !slices.Contains(pciKinds, kind) {
+			return nil, fmt.Errorf("invalid pci matcher %q, kind must be one of %v", matcher, pciKinds)
+		}
+		m := PCIMatcher{Kind: kind, Patterns: map[string]string{}}
+		for _, p := range strings.Split(patterns, "+") {
+			attribute, pattern, ok := strings.Cut(p, "=")
+			if !ok || pattern == "" {
+				return nil, fmt.Errorf("invalid pci matcher %q, must be kind:attribute=pattern", matcher)
+			}
+			if !slices.Contains(pciMatcherAttributes, attribute) {
+				return nil, fmt.Errorf("invalid pci matcher %q, attribute must be one of %v", matcher, pciMatcherAttributes)
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pci matcher %q %w", matcher, err)
+			}
+			m.Patterns[attribute] = strings.ToLower(pattern)
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// PCIDevice is a pci function with its classification
+type PCIDevice struct {
+	Address           string `json:"address"`
+	Kind              string `json:"kind,omitempty"`
+	VendorID          string `json:"vendor_id"`
+	Vendor            string `json:"vendor"`
+	DeviceID          string `json:"device_id"`
+	Device            string `json:"device"`
+	ClassID           string `json:"class_id"`
+	Class             string `json:"class"`
+	SubsystemVendorID string `json:"subsystem_vendor_id"`
+	SubsystemDeviceID string `json:"subsystem_device_id"`
+	NUMANode          int    `json:"numa_node"`
+	LinkSpeed         string `json:"link_speed,omitempty"`
+	LinkWidth         string `json:"link_width,omitempty"`
+	MaxLinkSpeed      string `json:"max_link_speed,omitempty"`
+	MaxLinkWidth      string `json:"max_link_width,omitempty"`
+}
+
+func (d PCIDevice) String() string {
+	s := fmt.Sprintf("%s %s %s:%s %s %s class:%s subsystem:%s:%s numa:%d", d.Address, d.Kind, d.VendorID, d.DeviceID, d.Vendor, d.Device, d.ClassID, d.SubsystemVendorID, d.SubsystemDeviceID, d.NUMANode)
+	if d.LinkSpeed != "" {
+		s += fmt.Sprintf(" link:%s x%s", d.LinkSpeed, d.LinkWidth)
+		if d.LinkSpeed != d.MaxLinkSpeed || d.LinkWidth != d.MaxLinkWidth {
+			s += fmt.Sprintf(" (max %s x%s)", d.MaxLinkSpeed, d.MaxLinkWidth)
+		}
+	}
+	return s
+}
+
+// Match returns the first matcher which matches the device
+func (m PCIMatchers) Match(d PCIDevice) (PCIMatcher, bool) {
+	attributes := map[string][]string{
+		"vendor": {d.VendorID},
+		"device": {d.DeviceID},
+		"class":  {d.ClassID},
+		"name":   {d.Vendor, d.Device},
+	}
+	for _, matcher := range m {
+		matches := true
+		for attribute, pattern := range matcher.Patterns {
+			if !matchAny(pattern, attributes[attribute]) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return matcher, true
+		}
+	}
+	return PCIMatcher{}, false
+}
+
+func matchAny(pattern string, values []string) bool {
+	for _, v := range values {
+		if ok, _ := path.Match(pattern, strings.ToLower(v)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readPCIDevices reads all pci functions and classifies them
+func readPCIDevices(matchers PCIMatchers) ([]PCIDevice, error) {
+	pciReader, err := pci.NewBusReader("*")
+	if err != nil {
+		return nil, err
+	}
+
+	var devices pci.Devices
+	if devices, err = pciReader.Read(); err != nil {
+		return nil, err
+	}
+
+	devices.SetVendorDeviceName(pci.IDs)
+
+	var result []PCIDevice
+	for _, p := range devices {
+		d := PCIDevice{
+			Address:  p.Addr,
+			VendorID: fmt.Sprintf("%04x", p.Vendor),
+			Vendor:   p.VendorName,
+			DeviceID: fmt.Sprintf("%04x", p.Device),
+			Device:   p.DeviceName,
+			// the class contains the programming interface in the lowest byte
+			ClassID: fmt.Sprintf("%04x", p.Class>>8),
+			Class:   p.ClassName,
+		}
+		readPCISysfs(filepath.Join(pciDevices, p.Addr), &d)
+		if m, ok := matchers.Match(d); ok {
+			d.Kind = m.Kind
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
+
+// readPCISysfs adds the attributes of the device directory in sysfs, missing attributes are left empty
+func readPCISysfs(dir string, d *PCIDevice) {
+	d.SubsystemVendorID = strings.TrimPrefix(os.ReadSysfs(dir, "subsystem_vendor"), "0x")
+	d.SubsystemDeviceID = strings.TrimPrefix(os.ReadSysfs(dir, "subsystem_device"), "0x")
+	d.NUMANode = -1
+	if n, err := strconv.Atoi(os.ReadSysfs(dir, "numa_node")); err == nil {
+		d.NUMANode = n
+	}
+	d.LinkSpeed = os.ReadSysfs(dir, "current_link_speed")
+	d.LinkWidth = os.ReadSysfs(dir, "current_link_width")
+	d.MaxLinkSpeed = os.ReadSysfs(dir, "max_link_speed")
+	d.MaxLinkWidth = os.ReadSysfs(dir, "max_link_width")
+}
+
+// classifiedPCIDevices reads all pci devices, logs them and reports the classified devices as event to metal-api
+// where they are stored in the event log of the machine. Only gpus are part of the register request, the hardware
+// of the boot service has no field for accelerators, fpgas and dpus and the proto of metal-api can not be extended from here.
+func (r *Register) classifiedPCIDevices() ([]PCIDevice, error) {
+	devices, err := readPCIDevices(r.pciMatchers)
+	if err != nil {
+		return nil, err
+	}
+	var classified []PCIDevice
+	for _, d := range devices {
+		r.log.Debug("pci", "device", d.String())
+		if d.Kind == "" {
+			continue
+		}
+		r.log.Info("found pci device", "kind", d.Kind, "device", d.String())
+		r.emitter.Emit(event.ProvisioningEventRegistering, "pci "+d.String())
+		classified = append(classified, d)
+	}
+	return classified, nil
+}
@@ -0,0 +1,142 @@
+package register
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePCIMatchers(t *testing.T) {
+	tests := []struct {
+		name     string
+		matchers string
+		want     PCIMatchers
+		wantErr  bool
+	}{
+		{
+			name:     "multiple matchers",
+			matchers: "gpu:vendor=10de+class=03*, accelerator:name=*Gaudi*",
+			want: PCIMatchers{
+				{Kind: PCIKindGPU, Patterns: map[string]string{"vendor": "10de", "class": "03*"}},
+				{Kind: PCIKindAccelerator, Patterns: map[string]string{"name": "*gaudi*"}},
+			},
+		},
+		{
+			name:     "invalid kind",
+			matchers: "nic:vendor=15b3",
+			wantErr:  true,
+		},
+		{
+			name:     "invalid attribute",
+			matchers: "gpu:numa=0",
+			wantErr:  true,
+		},
+		{
+			name:     "missing pattern",
+			matchers: "gpu:vendor=",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePCIMatchers(tt.matchers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePCIMatchers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePCIMatchers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPCIMatchersMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		device PCIDevice
+		want   string
+	}{
+		{
+			name:   "nvidia 3d controller",
+			device: PCIDevice{VendorID: "10de", DeviceID: "2330", ClassID: "0302", Vendor: "NVIDIA Corporation", Device: "GH100 [H100 SXM5 80GB]"},
+			want:   PCIKindGPU,
+		},
+		{
+			name:   "amd instinct",
+			device: PCIDevice{VendorID: "1002", DeviceID: "740f", ClassID: "0380", Vendor: "Advanced Micro Devices, Inc. [AMD/ATI]", Device: "Aldebaran/MI200 [Instinct MI210]"},
+			want:   PCIKindGPU,
+		},
+		{
+			name:   "bmc vga",
+			device: PCIDevice{VendorID: "1a03", DeviceID: "2000", ClassID: "0300", Vendor: "ASPEED Technology, Inc.", Device: "ASPEED Graphics Family"},
+		},
+		{
+			name:   "bluefield network function is a nic",
+			device: PCIDevice{VendorID: "15b3", DeviceID: "a2dc", ClassID: "0200", Vendor: "Mellanox Technologies", Device: "MT43244 BlueField-3 integrated ConnectX-7 network controller"},
+		},
+		{
+			name:   "bluefield management interface",
+			device: PCIDevice{VendorID: "15b3", DeviceID: "c2d5", ClassID: "0200", Vendor: "Mellanox Technologies", Device: "MT43244 BlueField-3 SoC Management Interface"},
+			want:   PCIKindDPU,
+		},
+		{
+			name:   "pensando ethernet controller is a nic",
+			device: PCIDevice{VendorID: "1dd8", DeviceID: "1002", ClassID: "0200", Vendor: "Pensando Systems", Device: "DSC Ethernet Controller"},
+		},
+		{
+			name:   "pensando management controller",
+			device: PCIDevice{VendorID: "1dd8", DeviceID: "1004", ClassID: "0880", Vendor: "Pensando Systems", Device: "DSC Management Controller"},
+			want:   PCIKindDPU,
+		},
+		{
+			name:   "xilinx alveo",
+			device: PCIDevice{VendorID: "10ee", DeviceID: "5004", ClassID: "1200", Vendor: "Xilinx Corporation"},
+			want:   PCIKindFPGA,
+		},
+		{
+			name:   "habana gaudi",
+			device: PCIDevice{VendorID: "1da3", DeviceID: "1020", ClassID: "1200", Vendor: "Habana Labs Ltd."},
+			want:   PCIKindAccelerator,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := DefaultPCIMatchers.Match(tt.device)
+			if ok != (tt.want != "") || m.Kind != tt.want {
+				t.Errorf("Match() = %v %v, want %q", m, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPCISysfs(t *testing.T) {
+	dir := t.TempDir()
+	for attribute, value := range map[string]string{
+		"subsystem_vendor":   "0x10de\n",
+		"subsystem_device":   "0x16c1\n",
+		"numa_node":          "1\n",
+		"current_link_speed": "16.0 GT/s PCIe\n",
+		"current_link_width": "8\n",
+		"max_link_speed":     "16.0 GT/s PCIe\n",
+		"max_link_width":     "16\n",
+	} {
+		err := os.WriteFile(filepath.Join(dir, attribute), []byte(value), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := PCIDevice{Address: "0000:bd:00.0", Kind: PCIKindGPU, VendorID: "10de", DeviceID: "26b1", ClassID: "0302"}
+	readPCISysfs(dir, &d)
+
+	want := "0000:bd:00.0 gpu 10de:26b1   class:0302 subsystem:10de:16c1 numa:1 link:16.0 GT/s PCIe x8 (max 16.0 GT/s PCIe x16)"
+	if got := d.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	d = PCIDevice{}
+	readPCISysfs(filepath.Join(dir, "missing"), &d)
+	if d.NUMANode != -1 || d.LinkSpeed != "" {
+		t.Errorf("readPCISysfs() of missing device = %v", d)
+	}
+}
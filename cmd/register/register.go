@@ -20,7 +20,6 @@ import (
 	"github.com/metal-stack/metal-hammer/cmd/network"
 	"github.com/metal-stack/metal-hammer/cmd/storage"
 	"github.com/metal-stack/v"
-	"github.com/u-root/u-root/pkg/pci"
 	"github.com/vishvananda/netlink"
 )
 
@@ -32,10 +31,13 @@ type Register struct {
 	emitter     *event.EventEmitter
 	network     *network.Network
 	inband      hal.InBand
+	protected   storage.ProtectedDisks
+	health      HealthThresholds
+	pciMatchers PCIMatchers
 	log         *slog.Logger
 }
 
-func New(log *slog.Logger, machineID, partitionID string, bootClient v1.BootServiceClient, emitter *event.EventEmitter, network *network.Network, inband hal.InBand) *Register {
+func New(log *slog.Logger, machineID, partitionID string, bootClient v1.BootServiceClient, emitter *event.EventEmitter, network *network.Network, inband hal.InBand, protected storage.ProtectedDisks, health HealthThresholds, pciMatchers PCIMatchers) *Register {
 	return &Register{
 		machineUUID: machineID,
 		partitionID: partitionID,
@@ -43,6 +45,9 @@ func New(log *slog.Logger, machineID, partitionID string, bootClient v1.BootServ
 		emitter:     emitter,
 		network:     network,
 		inband:      inband,
+		protected:   protected,
+		health:      health,
+		pciMatchers: pciMatchers,
 		log:         log,
 	}
 }
@@ -79,6 +84,7 @@ func (r *Register) readHardwareDetails() (*v1.BootServiceRegisterRequest, error)
 	if err != nil {
 		return nil, fmt.Errorf("unable to get system memory %w", err)
 	}
+	r.reportDIMMs()
 	// FIXME can be replaced by runtime.NumCPU()
 	cpu, err := ghw.CPU()
 	if err != nil {
@@ -97,17 +103,20 @@ func (r *Register) readHardwareDetails() (*v1.BootServiceRegisterRequest, error)
 
 	// 0000:bd:00.0: DisplayVGA: NVIDIA Corporation AD102GL [RTX 6000 Ada Generation]
 
-	gpus, err := r.detectGPUs()
+	pciDevices, err := r.classifiedPCIDevices()
 	if err != nil {
-		return nil, fmt.Errorf("unable to get system gpu(s) %w", err)
+		return nil, fmt.Errorf("unable to get system pci devices %w", err)
 	}
 
+	// accelerators, fpgas and dpus are only reported as events, the register request has no field for them
 	var metalGPUs []*v1.MachineGPU
-	for _, g := range gpus {
-		r.log.Info("found gpu", "gpu", g.String())
+	for _, d := range pciDevices {
+		if d.Kind != PCIKindGPU {
+			continue
+		}
 		metalGPUs = append(metalGPUs, &v1.MachineGPU{
-			Vendor: g.VendorName,
-			Model:  g.DeviceName,
+			Vendor: d.Vendor,
+			Model:  d.Device,
 		})
 	}
 
@@ -142,6 +151,15 @@ func (r *Register) readHardwareDetails() (*v1.BootServiceRegisterRequest, error)
 		}
 		r.log.Info("register", "nic", name, "mac", mac)
 		nics = append(nics, nic)
+
+		// the details are reported as event to metal-api where they are stored in the event log of the machine,
+		// a registered nic only carries its name, mac and neighbors and the proto of metal-api can not be extended from here
+		details := r.network.NICDetails(name, mac)
+		r.log.Info("nic", "details", details.String())
+		// only physical nics have a pci device
+		if details.PCIAddress != "" {
+			r.emitter.Emit(event.ProvisioningEventRegistering, "nic "+details.String())
+		}
 	}
 	// add a lo interface if not present
 	// this is required to have this interface present
@@ -174,10 +192,16 @@ func (r *Register) readHardwareDetails() (*v1.BootServiceRegisterRequest, error)
 		return nil, fmt.Errorf("unable to get system block devices %w", err)
 	}
 	disks := []*v1.MachineBlockDevice{}
+	var registered []*ghw.Disk
 	for _, disk := range blockInfo.Disks {
 		if strings.HasPrefix(disk.Name, storage.DiskPrefixToIgnore) {
 			continue
 		}
+		if rule, ok := r.protected.Match(disk); ok {
+			r.log.Warn("skip registration of protected disk", "disk", disk.Name, "serial", disk.SerialNumber, "model", disk.Model, "rule", rule)
+			r.emitter.Emit(event.ProvisioningEventRegistering, fmt.Sprintf("disk /dev/%s serial:%s is protected by rule %s, not registered", disk.Name, disk.SerialNumber, rule))
+			continue
+		}
 		size := uint64(disk.SizeBytes)
 		diskName := disk.Name
 		if !strings.HasPrefix(diskName, "/dev/") {
@@ -188,6 +212,11 @@ func (r *Register) readHardwareDetails() (*v1.BootServiceRegisterRequest, error)
 			Size: size,
 		}
 		disks = append(disks, blockDevice)
+		registered = append(registered, disk)
+	}
+	err = r.checkDiskHealth(registered)
+	if err != nil {
+		return nil, err
 	}
 
 	hardware := &v1.MachineHardware{
@@ -229,36 +258,6 @@ func (r *Register) readHardwareDetails() (*v1.BootServiceRegisterRequest, error)
 	return request, nil
 }
 
-func (r *Register) detectGPUs() (pci.Devices, error) {
-	pciReader, err := pci.NewBusReader("*")
-	if err != nil {
-		return nil, err
-	}
-
-	var devices pci.Devices
-	if devices, err = pciReader.Read(); err != nil {
-		return nil, err
-	}
-
-	devices.SetVendorDeviceName(pci.IDs)
-
-	var result pci.Devices
-	for _, device := range devices {
-		// "vendor":"NVIDIA Corporation","device":"AD102GL [RTX 6000 Ada Generation]"}
-		if !strings.Contains(strings.ToLower(device.VendorName), "nvidia") {
-			continue
-		}
-		r.log.Info("add gpu", "vendor", device.VendorName, "device", device.DeviceName, "classname", device.ClassName)
-
-		if !strings.Contains(strings.ToLower(device.ClassName), "display") {
-			continue
-		}
-		result = append(result, device)
-	}
-
-	return result, nil
-}
-
 // save the content of kernel ring buffer to /var/log/syslog
 // by calling the appropriate syscall.
 // Only required if Memory is gathered by ghw.Memory()
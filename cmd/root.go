@@ -96,7 +96,13 @@ func Run(log *slog.Logger, spec *Specification, hal hal.InBand) (*event.EventEmi
 		return eventEmitter, fmt.Errorf("interfaces %w", err)
 	}
 
-	reg := register.New(log, spec.MachineUUID, spec.MetalConfig.Partition, bootService, eventEmitter, n, hal)
+	// the disks which are registered and wiped are exported by the raid controller
+	err = hammer.ConfigureRaidController()
+	if err != nil {
+		return eventEmitter, fmt.Errorf("raid controller %w", err)
+	}
+
+	reg := register.New(log, spec.MachineUUID, spec.MetalConfig.Partition, bootService, eventEmitter, n, hal, spec.ProtectedDisks, spec.DiskHealth, spec.PCIMatchers)
 
 	err = reg.RegisterMachine()
 	if err != nil {
@@ -113,6 +119,11 @@ func Run(log *slog.Logger, spec *Specification, hal hal.InBand) (*event.EventEmi
 		primaryDiskWiped := false
 		if m.Allocation.Image == nil || m.Allocation.Image.ID == nil {
 			err = fmt.Errorf("no image specified")
+		} else if hammer.filesystemLayout == nil {
+			err = fmt.Errorf("no filesystem layout specified")
+		} else if err = storage.New(log, hammer.chrootPrefix, *hammer.filesystemLayout).Validate(); err != nil {
+			// validation happens before any disk is touched, the existing os is still bootable
+			log.Error("filesystem layout does not match this machine", "error", err)
 		} else {
 			log.Info("perform reinstall", "machineID", *m.ID, "imageID", *m.Allocation.Image.ID)
 			err = hammer.installImage(eventEmitter, bootService, m)
@@ -125,7 +136,20 @@ func Run(log *slog.Logger, spec *Specification, hal hal.InBand) (*event.EventEmi
 		return eventEmitter, err
 	}
 
-	err = storage.NewDisks(log).Wipe()
+	disks := storage.NewDisks(log).
+		WithPolicy(spec.WipePolicy).
+		WithProtectedDisks(spec.ProtectedDisks).
+		WithConcurrency(spec.WipeConcurrency).
+		WithProgress(hammer.reportWipeProgress)
+	signer, subject, err := metalAPIClient.Signer()
+	if err != nil {
+		log.Error("unable to sign wipe certificates", "error", err)
+	} else {
+		disks = disks.WithSigner(signer, subject)
+	}
+	certificates, err := disks.Wipe()
+	// the results are also reported if the wipe failed, metal-api keeps them in the event log of the machine
+	hammer.reportWipeCertificates(certificates)
 	if err != nil {
 		return eventEmitter, fmt.Errorf("wipe %w", err)
 	}
@@ -138,6 +162,12 @@ func Run(log *slog.Logger, spec *Specification, hal hal.InBand) (*event.EventEmi
 
 	eventEmitter.Emit(event.ProvisioningEventWaiting, "waiting for allocation")
 
+	// the burn-in runs before the machine is offered for allocation, the verdict is reported as event
+	err = hammer.BurnIn(m)
+	if err != nil {
+		return eventEmitter, err
+	}
+
 	err = apigrpc.WaitForAllocation(context.Background(), log, metalAPIClient.BootService(), spec.MachineUUID, defaultWaitTimeOut)
 	if err != nil {
 		return eventEmitter, fmt.Errorf("wait for installation %w", err)
@@ -188,6 +218,8 @@ func (h *hammer) installImage(eventEmitter *event.EventEmitter, bootService v1.B
 	// h.log.Info("waiting 10 sec to enable os debugging")
 	// time.Sleep(10 * time.Second)
 
+	h.waitForRaidSync()
+
 	eventEmitter.Emit(event.ProvisioningEventBootingNewKernel, "booting into distro kernel")
 	return kernel.RunKexec(info)
 }
@@ -4,9 +4,13 @@ import (
 	"log/slog"
 	"strconv"
 	"strings"
+	"time"
 
 	"os"
 
+	"github.com/metal-stack/metal-hammer/cmd/burnin"
+	"github.com/metal-stack/metal-hammer/cmd/register"
+	"github.com/metal-stack/metal-hammer/cmd/storage"
 	"github.com/metal-stack/metal-hammer/pkg/kernel"
 	pixiecore "github.com/metal-stack/pixie/api"
 )
@@ -27,6 +31,20 @@ type Specification struct {
 	MachineUUID string
 	// IP of this instance
 	IP string
+	// RaidResyncWait is the maximum duration to wait for md arrays to be in sync before booting into the new kernel, zero disables waiting.
+	RaidResyncWait time.Duration
+	// WipePolicy is either strict or lenient, with strict the provisioning fails if a disk could not be wiped.
+	WipePolicy storage.WipePolicy
+	// WipeConcurrency is the number of disks of the same controller which are wiped in parallel
+	WipeConcurrency int
+	// ProtectedDisks are neither wiped nor registered
+	ProtectedDisks storage.ProtectedDisks
+	// DiskHealth defines when a disk is considered failing and if machines with failing disks are registered
+	DiskHealth register.HealthThresholds
+	// PCIMatchers classify pci devices as gpu, accelerator, fpga or dpu
+	PCIMatchers register.PCIMatchers
+	// BurnIn stresses disks, cpus and memory before the machine is waiting for allocation
+	BurnIn burnin.Config
 	// MetalConfig is fetched from pixiecore to get the certs for the metal-api and logging config
 	MetalConfig *pixiecore.MetalConfig
 
@@ -35,7 +53,13 @@ type Specification struct {
 
 // NewSpec fills Specification with configuration made by kernel commandline
 func NewSpec(log *slog.Logger) *Specification {
-	spec := &Specification{}
+	spec := &Specification{
+		WipePolicy:      storage.WipePolicyStrict,
+		WipeConcurrency: storage.DefaultWipeConcurrency,
+		DiskHealth:      register.DefaultHealthThresholds,
+		BurnIn:          burnin.DefaultConfig,
+		PCIMatchers:     register.DefaultPCIMatchers,
+	}
 	// Grab metal-hammer configuration from kernel commandline
 	envmap, err := kernel.ParseCmdline()
 	if err != nil {
@@ -67,6 +91,76 @@ func NewSpec(log *slog.Logger) *Specification {
 			spec.BGPEnabled = enabled
 		}
 	}
+	if wait, ok := envmap["RAID_RESYNC_WAIT"]; ok {
+		duration, err := time.ParseDuration(wait)
+		if err != nil {
+			log.Error("unable to parse RAID_RESYNC_WAIT, not waiting for raid resync", "value", wait, "error", err)
+		} else {
+			spec.RaidResyncWait = duration
+		}
+	}
+	if policy, ok := envmap["WIPE_POLICY"]; ok {
+		switch storage.WipePolicy(policy) {
+		case storage.WipePolicyStrict, storage.WipePolicyLenient:
+			spec.WipePolicy = storage.WipePolicy(policy)
+		default:
+			log.Error("unable to parse WIPE_POLICY, using strict", "value", policy)
+		}
+	}
+	if concurrency, ok := envmap["WIPE_CONCURRENCY"]; ok {
+		c, err := strconv.Atoi(concurrency)
+		if err != nil || c < 1 {
+			log.Error("unable to parse WIPE_CONCURRENCY, using default", "value", concurrency, "default", storage.DefaultWipeConcurrency)
+		} else {
+			spec.WipeConcurrency = c
+		}
+	}
+	// the rules are given as WIPE_PROTECT=transport:usb,serial:S3Z9NB0K*
+	if rules, ok := envmap["WIPE_PROTECT"]; ok {
+		protected, err := storage.ParseProtectedDisks(rules)
+		if err != nil {
+			log.Error("unable to parse WIPE_PROTECT", "value", rules, "error", err)
+			os.Exit(1)
+		}
+		spec.ProtectedDisks = protected
+	}
+	// the policy is given as DISK_HEALTH=ignore|warn|refuse, the thresholds as DISK_HEALTH_THRESHOLDS=reallocated:10,used:90
+	policy, hasPolicy := envmap["DISK_HEALTH"]
+	thresholds, hasThresholds := envmap["DISK_HEALTH_THRESHOLDS"]
+	if hasPolicy || hasThresholds {
+		health, err := register.ParseHealthThresholds(policy, thresholds)
+		if err != nil {
+			log.Error("unable to parse DISK_HEALTH", "policy", policy, "thresholds", thresholds, "error", err)
+			os.Exit(1)
+		}
+		spec.DiskHealth = health
+	}
+	// additional matchers are given as PCI_MATCH=accelerator:vendor=1e52,gpu:name=*Radeon*
+	// and take precedence over the default matchers
+	if matchers, ok := envmap["PCI_MATCH"]; ok {
+		m, err := register.ParsePCIMatchers(matchers)
+		if err != nil {
+			log.Error("unable to parse PCI_MATCH, using default matchers", "value", matchers, "error", err)
+		} else {
+			spec.PCIMatchers = append(m, register.DefaultPCIMatchers...)
+		}
+	}
+	if b, ok := envmap["BURNIN"]; ok {
+		enabled, err := strconv.ParseBool(b)
+		if err != nil {
+			log.Error("unable to parse BURNIN, burn-in disabled", "value", b, "error", err)
+		} else {
+			spec.BurnIn.Enabled = enabled
+		}
+	}
+	if d, ok := envmap["BURNIN_DURATION"]; ok {
+		duration, err := time.ParseDuration(d)
+		if err != nil {
+			log.Error("unable to parse BURNIN_DURATION, using default", "value", d, "default", spec.BurnIn.Duration, "error", err)
+		} else {
+			spec.BurnIn.Duration = duration
+		}
+	}
 	spec.log = log
 
 	return spec
@@ -81,5 +175,12 @@ func (s *Specification) Log() {
 		"cidr", s.Cidr,
 		"machineUUID", s.MachineUUID,
 		"ip", s.IP,
+		"raidresyncwait", s.RaidResyncWait,
+		"wipepolicy", s.WipePolicy,
+		"wipeconcurrency", s.WipeConcurrency,
+		"protecteddisks", s.ProtectedDisks,
+		"diskhealth", s.DiskHealth,
+		"burnin", s.BurnIn,
+		"pcimatchers", s.PCIMatchers,
 	)
 }
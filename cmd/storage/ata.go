@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	gos "os"
+
+	"github.com/metal-stack/metal-hammer/pkg/os"
+	"github.com/metal-stack/metal-hammer/pkg/os/command"
+)
+
+// ataSecurityPassword is set temporarily to be able to issue SECURITY ERASE UNIT, the erase removes it again
+const ataSecurityPassword = "metal-hammer"
+
+// ataSecurity is the security feature set of an ata disk as reported by hdparm -I
+type ataSecurity struct {
+	Supported        bool
+	Enabled          bool
+	Locked           bool
+	Frozen           bool
+	EnhancedSupports bool
+}
+
+// isATADisk returns true for disks attached with libata, e.g. sata ssds
+func isATADisk(deviceName string) bool {
+	vendor, err := gos.ReadFile(filepath.Join(sysBlock, deviceName, "device", "vendor"))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(vendor)) == "ATA"
+}
+
+// parseATASecurity parses the security section of hdparm -I:
+//
+//	Security:
+//		Master password revision code = 65534
+//			supported
+//		not	enabled
+//		not	locked
+//			frozen
+//		not	expired: security count
+//			supported: enhanced erase
+//		2min for SECURITY ERASE UNIT. 2min for ENHANCED SECURITY ERASE UNIT.
+func parseATASecurity(output string) ataSecurity {
+	var (
+		s         ataSecurity
+		inSection bool
+	)
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "Security:") {
+			inSection = true
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		// the next section starts without indentation
+		if line != "" && !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, " ") {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		negated := fields[0] == "not"
+		if negated {
+			fields = fields[1:]
+		}
+		switch strings.Join(fields, " ") {
+		case "supported":
+			s.Supported = !negated
+		case "enabled":
+			s.Enabled = !negated
+		case "locked":
+			s.Locked = !negated
+		case "frozen":
+			s.Frozen = !negated
+		case "supported: enhanced erase":
+			s.EnhancedSupports = !negated
+		}
+	}
+	return s
+}
+
+// secureEraseATA issues SECURITY ERASE UNIT to the disk, the enhanced variant is used if supported
+// because it also erases reallocated sectors.
+func (d *Disks) secureEraseATA(device string) error {
+	path, err := exec.LookPath(command.HDParm)
+	if err != nil {
+		return fmt.Errorf("unable to locate program:%s in path %w", command.HDParm, err)
+	}
+	out, err := exec.Command(path, "-I", device).Output()
+	if err != nil {
+		return fmt.Errorf("unable to identify %s %w", device, err)
+	}
+	security := parseATASecurity(string(out))
+	d.log.Info("wipe", "disk", device, "ata security", security)
+
+	switch {
+	case !security.Supported:
+		return fmt.Errorf("ata security is not supported")
+	case security.Frozen:
+		// the bios freezes the security state during boot, it is only unfrozen by a power cycle of the disk,
+		// e.g. a hot plug or a power cycle of the machine via the bmc.
+		return fmt.Errorf("ata security is frozen, power cycle the machine with the bmc or replug the disk to unfreeze it")
+	case security.Locked:
+		return fmt.Errorf("ata security is locked with an unknown password")
+	case security.Enabled:
+		return fmt.Errorf("ata security is enabled with an unknown password")
+	}
+
+	d.log.Info("wipe", "disk", device, "message", "set temporary ata security password")
+	err = os.ExecuteCommand(command.HDParm, "--user-master", "u", "--security-set-pass", ataSecurityPassword, device)
+	if err != nil {
+		return fmt.Errorf("unable to set ata security password %w", err)
+	}
+
+	erase := "--security-erase"
+	if security.EnhancedSupports {
+		erase = "--security-erase-enhanced"
+	}
+	d.log.Info("wipe", "disk", device, "message", "start ata security erase", "mode", erase)
+	err = os.ExecuteCommand(command.HDParm, "--user-master", "u", erase, ataSecurityPassword, device)
+	if err != nil {
+		// do not leave the disk with the temporary password behind
+		disableErr := os.ExecuteCommand(command.HDParm, "--user-master", "u", "--security-disable", ataSecurityPassword, device)
+		if disableErr != nil {
+			d.log.Error("wipe", "disk", device, "message", "unable to remove temporary ata security password", "error", disableErr)
+		}
+		return fmt.Errorf("ata security erase failed %w", err)
+	}
+	d.log.Info("wipe", "disk", device, "message", "finish ata security erase")
+	return nil
+}
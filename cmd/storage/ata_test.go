@@ -0,0 +1,35 @@
+package storage
+
+import "testing"
+
+func TestParseATASecurity(t *testing.T) {
+	output := `/dev/sda:
+
+ATA device, with non-removable media
+	Model Number:       Samsung SSD 860 EVO 500GB
+Commands/features:
+	Enabled	Supported:
+	   *	SMART feature set
+Security: 
+	Master password revision code = 65534
+		supported
+	not	enabled
+	not	locked
+		frozen
+	not	expired: security count
+		supported: enhanced erase
+	2min for SECURITY ERASE UNIT. 2min for ENHANCED SECURITY ERASE UNIT.
+Logical Unit WWN Device Identifier: 5002538e40a1b2c3
+Checksum: correct
+`
+	got := parseATASecurity(output)
+	want := ataSecurity{Supported: true, Frozen: true, EnhancedSupports: true}
+	if got != want {
+		t.Errorf("parseATASecurity() = %+v, want %+v", got, want)
+	}
+
+	got = parseATASecurity("/dev/sda:\n\nATA device, with non-removable media\n")
+	if got.Supported {
+		t.Errorf("parseATASecurity() without security section must not be supported")
+	}
+}
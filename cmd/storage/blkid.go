@@ -2,7 +2,6 @@ package storage
 
 import (
 	"fmt"
-	"os/exec"
 	"strings"
 
 	"github.com/metal-stack/metal-hammer/pkg/os/command"
@@ -10,11 +9,7 @@ import (
 
 // FetchBlockIDProperties use blkid to return more properties of the given partition device
 func FetchBlockIDProperties(partitionDevice string) (map[string]string, error) {
-	path, err := exec.LookPath(command.BlkID)
-	if err != nil {
-		return nil, fmt.Errorf("unable to locate program:%s in path %w", command.BlkID, err)
-	}
-	out, err := exec.Command(path, "-o", "export", partitionDevice).CombinedOutput()
+	out, err := reportOutput(command.BlkID, "-o", "export", partitionDevice)
 	if err != nil {
 		return nil, fmt.Errorf("unable to execute %s %s output:%s %w", command.BlkID, partitionDevice, out, err)
 	}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	gos "os"
+
+	"github.com/metal-stack/metal-hammer/pkg/api"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// wipeVerifyBlock is the size of every sample read from the erased disk
+	wipeVerifyBlock = 4096
+	// wipeVerifyEdge is the size read from the beginning and the end of the disk where partition tables and metadata reside
+	wipeVerifyEdge = 1024 * 1024
+	// wipeVerifySamples is the number of randomly chosen blocks read from the erased disk
+	wipeVerifySamples = 256
+)
+
+// wipeStatusCompleted is the status of a crypto erase which the disk reported as completed
+const wipeStatusCompleted = "completed"
+
+// verifyErase verifies the erased disk. A crypto erase only destroys the media encryption key, a disk which does not
+// deallocate its blocks afterwards returns random data. The erase method already checked the completion status
+// reported by the disk, e.g. the sanitize log or the sense data, which is recorded instead of a pattern.
+func verifyErase(m wipeMethod, device string, size uint64) api.WipeVerification {
+	if m.crypto {
+		return api.WipeVerification{Status: wipeStatusCompleted}
+	}
+	return verifyWipe(device, size)
+}
+
+// verifyWipe reads the beginning, the end and random blocks of the erased device,
+// all bytes must be the same value, depending on the method and the device this is either 0x00 or 0xff.
+func verifyWipe(device string, size uint64) api.WipeVerification {
+	v := api.WipeVerification{}
+	f, err := gos.Open(device)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	defer f.Close()
+
+	// drop cached pages, the device was possibly erased by its firmware behind the back of the kernel
+	err = unix.IoctlSetInt(int(f.Fd()), unix.BLKFLSBUF, 0)
+	if err != nil {
+		v.Error = fmt.Sprintf("unable to flush buffers %s", err)
+		return v
+	}
+
+	pattern, samples, err := verifyPattern(f, size, wipeVerifySamples)
+	v.Samples = samples
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	v.Pattern = fmt.Sprintf("0x%02x", pattern)
+	return v
+}
+
+// verifyPattern checks that all samples consist of the same byte and returns it
+func verifyPattern(r io.ReaderAt, size uint64, samples int) (byte, int, error) {
+	type sample struct {
+		offset uint64
+		length uint64
+	}
+	edge := min(uint64(wipeVerifyEdge), size)
+	checks := []sample{{offset: 0, length: edge}, {offset: size - edge, length: edge}}
+	if blocks := size / wipeVerifyBlock; blocks > 0 {
+		for range samples {
+			checks = append(checks, sample{offset: mrand.Uint64N(blocks) * wipeVerifyBlock, length: wipeVerifyBlock}) // nolint:gosec
+		}
+	}
+
+	var (
+		pattern byte
+		first   = true
+	)
+	for i, c := range checks {
+		buf := make([]byte, c.length)
+		_, err := r.ReadAt(buf, int64(c.offset)) // nolint:gosec
+		if err != nil {
+			return 0, i, fmt.Errorf("unable to read at offset %d %w", c.offset, err)
+		}
+		for j, b := range buf {
+			if first {
+				pattern = b
+				first = false
+			}
+			if b != pattern {
+				return 0, i + 1, fmt.Errorf("unexpected data 0x%02x at offset %d, expected 0x%02x", b, c.offset+uint64(j), pattern) // nolint:gosec
+			}
+		}
+	}
+	return pattern, len(checks), nil
+}
+
+// sign the certificate, the signature covers the json representation of the certificate without signature
+func (d *Disks) sign(certificate *api.WipeCertificate) error {
+	if d.signer == nil {
+		return nil
+	}
+	certificate.Signer = d.signerSubject
+	certificate.Signature = ""
+	content, err := json.Marshal(certificate)
+	if err != nil {
+		return err
+	}
+
+	var signature []byte
+	if _, ok := d.signer.Public().(ed25519.PublicKey); ok {
+		signature, err = d.signer.Sign(rand.Reader, content, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(content)
+		signature, err = d.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return err
+	}
+	certificate.Signature = base64.StdEncoding.EncodeToString(signature)
+	return nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/metal-stack/metal-hammer/pkg/api"
+)
+
+func TestVerifyPattern(t *testing.T) {
+	size := 8 * 1024 * 1024
+
+	zeros := make([]byte, size)
+	pattern, samples, err := verifyPattern(bytes.NewReader(zeros), uint64(size), 16)
+	if err != nil {
+		t.Fatalf("verifyPattern() error = %v", err)
+	}
+	if pattern != 0x00 || samples != 18 {
+		t.Errorf("verifyPattern() = 0x%02x %d, want 0x00 18", pattern, samples)
+	}
+
+	ones := bytes.Repeat([]byte{0xff}, size)
+	pattern, _, err = verifyPattern(bytes.NewReader(ones), uint64(size), 16)
+	if err != nil || pattern != 0xff {
+		t.Errorf("verifyPattern() = 0x%02x %v, want 0xff", pattern, err)
+	}
+
+	// a partition table left at the end of the disk
+	leftover := make([]byte, size)
+	copy(leftover[size-512:], "EFI PART")
+	_, _, err = verifyPattern(bytes.NewReader(leftover), uint64(size), 16)
+	if err == nil {
+		t.Errorf("verifyPattern() expected error for data at the end of the disk")
+	}
+}
+
+func TestVerifyErase(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    wipeMethod
+		want      string
+		wantError bool
+	}{
+		{
+			name:   "crypto erase is verified by the completion status",
+			method: wipeMethod{name: "nvme-sanitize-crypto", crypto: true},
+			want:   wipeStatusCompleted,
+		},
+		{
+			name:      "other erases must be verified by a pattern",
+			method:    wipeMethod{name: "discard"},
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := verifyErase(tt.method, "/dev/does-not-exist", 1024)
+			if got.Status != tt.want {
+				t.Errorf("verifyErase() status = %q, want %q", got.Status, tt.want)
+			}
+			if (got.Error != "") != tt.wantError {
+				t.Errorf("verifyErase() error = %q, wantError %v", got.Error, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestSignCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDisks(slog.Default()).WithSigner(key, "CN=metal-hammer")
+
+	certificate := api.WipeCertificate{Device: "/dev/sda", Serial: "S1", Method: "dd", Verified: true}
+	err = d.sign(&certificate)
+	if err != nil {
+		t.Fatalf("sign() error = %v", err)
+	}
+	if certificate.Signer != "CN=metal-hammer" {
+		t.Errorf("sign() signer = %q", certificate.Signer)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(certificate.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := certificate
+	unsigned.Signature = ""
+	content, err := json.Marshal(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(content)
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Errorf("sign() signature does not verify")
+	}
+}
@@ -6,19 +6,19 @@ import (
 	"github.com/u-root/u-root/pkg/mount/block"
 	"log/slog"
 	gos "os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/metal-stack/metal-go/api/models"
 	"github.com/metal-stack/metal-hammer/pkg/api"
-	"github.com/metal-stack/metal-hammer/pkg/os"
+	"github.com/metal-stack/metal-hammer/pkg/kernel"
 	"github.com/metal-stack/metal-hammer/pkg/os/command"
-	"github.com/metal-stack/v"
 )
 
 type Filesystem struct {
@@ -26,7 +26,21 @@ type Filesystem struct {
 	// chroot defines the root of the mounts
 	chroot string
 	// mounts are collected to be able to umount all in reverse order
-	mounts       []string
+	mounts []string
+	// specialMounts are the special filesystems mounted inside the chroot
+	specialMounts []string
+	// created are all storage objects created so far, used for rollback
+	created []createdObject
+	// pvcount is the number of pvs of all volumegroups created
+	pvcount map[string]int
+	// kept are the devices of existing filesystems which were not formatted
+	kept map[string]bool
+	// workers is the maximum number of storage objects created in parallel
+	workers int
+	// firmware is either efi or bios, with bios a bios boot partition is added to gpt disks
+	firmware string
+	// mu protects created, pvcount and kept which are modified by parallel workers
+	mu           sync.Mutex
 	fstabEntries fstabEntries
 	// disk is the legacy disk.json representatio
 	// TODO remove once old images are gone
@@ -52,32 +66,39 @@ func New(log *slog.Logger, chroot string, config models.V1FilesystemLayoutRespon
 		config:       config,
 		chroot:       chroot,
 		fstabEntries: fstabEntries{},
+		pvcount:      map[string]int{},
+		kept:         map[string]bool{},
+		workers:      runtime.NumCPU(),
+		firmware:     kernel.Firmware(),
 		disk:         api.Disk{Device: "legacy", Partitions: []api.Partition{}},
 		log:          log,
 	}
 }
 
-func (f *Filesystem) Run() error {
-
-	err := f.createPartitions()
+// Run creates and mounts the filesystem layout. If any step fails,
+// everything created so far is removed again.
+func (f *Filesystem) Run() (err error) {
+	err = f.Validate()
 	if err != nil {
-		return fmt.Errorf("create partitions failed:%w", err)
+		return err
 	}
 
-	err = f.createRaids()
-	if err != nil {
-		return fmt.Errorf("create raids failed:%w", err)
-	}
+	defer func() {
+		if err != nil {
+			f.rollback()
+		}
+	}()
 
-	err = f.createLogicalVolumes()
+	err = f.createNamespaces()
 	if err != nil {
-		return fmt.Errorf("create logical volumes failed:%w", err)
+		return err
 	}
 
-	err = f.createFilesystems()
+	err = f.createStorage()
 	if err != nil {
-		return fmt.Errorf("create filesystems failed:%w", err)
+		return err
 	}
+	f.createDeviceLinks()
 
 	err = f.mountFilesystems()
 	if err != nil {
@@ -93,250 +114,312 @@ func (f *Filesystem) Run() error {
 	if err != nil {
 		return fmt.Errorf("disk.json creation failed:%w", err)
 	}
+
+	err = f.createLayoutJSON()
+	if err != nil {
+		return fmt.Errorf("layout.json creation failed:%w", err)
+	}
 	return nil
 }
 func (f *Filesystem) Umount() {
 	f.umountFilesystems()
 }
 
-func (f *Filesystem) createPartitions() error {
-	if len(f.config.Disks) == 0 {
+func (f *Filesystem) createPartitions(disk *models.V1Disk) error {
+	if disk.Device == nil {
 		return nil
 	}
-	for _, disk := range f.config.Disks {
-		opts := []string{}
+	opts := []string{}
 
-		if disk.Wipeonreinstall != nil && *disk.Wipeonreinstall {
-			opts = append(opts, "--zap-all")
+	if disk.Wipeonreinstall != nil && *disk.Wipeonreinstall {
+		opts = append(opts, "--zap-all")
+	}
+	for _, p := range disk.Partitions {
+		if p.Size != nil {
+			opts = append(opts, fmt.Sprintf("--new=%d:0:+%dM", *p.Number, *p.Size))
 		}
-		for _, p := range disk.Partitions {
-			if p.Size != nil {
-				opts = append(opts, fmt.Sprintf("--new=%d:0:+%dM", *p.Number, *p.Size))
-			}
-			opts = append(opts, fmt.Sprintf("--change-name=%d:%s", *p.Number, p.Label))
-			if p.Gpttype != nil {
-				opts = append(opts, fmt.Sprintf("--typecode=%d:%s", *p.Number, *p.Gpttype))
-			}
+		opts = append(opts, fmt.Sprintf("--change-name=%d:%s", *p.Number, p.Label))
+		if p.Gpttype != nil {
+			opts = append(opts, fmt.Sprintf("--typecode=%d:%s", *p.Number, *p.Gpttype))
 		}
-		if disk.Device != nil {
-			f.log.Info("wipe existing partition signatures", "command", command.WIPEFS+" --all"+" "+*disk.Device)
-			err := os.ExecuteCommand(command.WIPEFS, "--all", *disk.Device)
-			if err != nil {
-				f.log.Error("wipe existing partition signatures failed", "error", err)
-				return fmt.Errorf("unable wipe existing partitions on %s %w", *disk.Device, err)
-			}
-			opts = append(opts, *disk.Device)
-			f.log.Info("sgdisk create partitions", "command", opts)
-			err = os.ExecuteCommand(command.SGDisk, opts...)
-			if err != nil {
-				f.log.Error("sgdisk creating partitions failed", "error", err)
-				return fmt.Errorf("unable to create partitions on %s %w", *disk.Device, err)
-			}
+	}
 
-			blkdev, err := block.Device(*disk.Device)
-			if err != nil {
-				return fmt.Errorf("unable to find block device %s: %v", *disk.Device, err)
-			}
+	if needsBIOSBootPartition(f.firmware, disk) {
+		opts = append(opts, biosBootPartitionArgs()...)
+	}
 
-			err = blkdev.ReadPartitionTable()
-			if err != nil {
-				return fmt.Errorf("unable to re-read the partition table. Kernel still uses old partition table: %v", err)
-			}
+	f.track(kindPartitionTable, *disk.Device)
+	f.log.Info("wipe existing partition signatures", "command", command.WIPEFS+" --all"+" "+*disk.Device)
+	err := executeCommand(command.WIPEFS, "--all", *disk.Device)
+	if err != nil {
+		f.log.Error("wipe existing partition signatures failed", "error", err)
+		return fmt.Errorf("unable wipe existing partitions on %s %w", *disk.Device, err)
+	}
+	if partitionTableType(disk) == "dos" {
+		err = f.createMBRPartitions(disk)
+		if err != nil {
+			return err
+		}
+	} else {
+		opts = append(opts, *disk.Device)
+		f.log.Info("sgdisk create partitions", "command", opts)
+		err = executeCommand(command.SGDisk, opts...)
+		if err != nil {
+			f.log.Error("sgdisk creating partitions failed", "error", err)
+			return fmt.Errorf("unable to create partitions on %s %w", *disk.Device, err)
 		}
 	}
-	return nil
-}
 
-func (f *Filesystem) createRaids() error {
-	if len(f.config.Raid) == 0 {
-		return nil
+	blkdev, err := block.Device(*disk.Device)
+	if err != nil {
+		return fmt.Errorf("unable to find block device %s: %v", *disk.Device, err)
 	}
 
-	for _, raid := range f.config.Raid {
-		if raid.Arrayname == nil {
-			continue
-		}
-		spares := int32(0)
-		if raid.Spares != nil {
-			spares = *raid.Spares
-		}
-		level := "1"
-		if raid.Level != nil {
-			level = *raid.Level
-		}
-		args := []string{
-			"--create", *raid.Arrayname,
-			"--force",
-			"--run",
-			"--homehost", "any",
-			"--level", level,
-			"--raid-devices", fmt.Sprintf("%d", len(raid.Devices)-int(spares)),
-		}
+	err = blkdev.ReadPartitionTable()
+	if err != nil {
+		return fmt.Errorf("unable to re-read the partition table. Kernel still uses old partition table: %v", err)
+	}
 
-		switch level {
-		case "0", "1":
-			args = append(args, "--assume-clean")
-		default:
-			// only safe to skip initial sync for raid 0 and 1
-			// see https://raid.wiki.kernel.org/index.php/Initial_Array_Creation#raid5
+	var partitions []string
+	for _, p := range disk.Partitions {
+		if p.Number != nil {
+			partitions = append(partitions, partitionDevice(*disk.Device, *p.Number))
 		}
+	}
+	return waitForDevices(f.log, settleTimeout, partitions...)
+}
 
-		if spares > 0 {
-			args = append(args, "--spare-devices", fmt.Sprintf("%d", spares))
-		}
+func (f *Filesystem) createRaid(raid *models.V1Raid) error {
+	if raid.Arrayname == nil {
+		return nil
+	}
+	spares := int32(0)
+	if raid.Spares != nil {
+		spares = *raid.Spares
+	}
+	level := "1"
+	if raid.Level != nil {
+		level = *raid.Level
+	}
+	args := []string{
+		"--create", *raid.Arrayname,
+		"--force",
+		"--run",
+		"--homehost", "any",
+		"--level", level,
+		"--raid-devices", fmt.Sprintf("%d", len(raid.Devices)-int(spares)),
+	}
 
-		for _, o := range raid.Createoptions {
-			args = append(args, string(o))
-		}
+	switch level {
+	case "0", "1":
+		args = append(args, "--assume-clean")
+	default:
+		// only safe to skip initial sync for raid 0 and 1
+		// see https://raid.wiki.kernel.org/index.php/Initial_Array_Creation#raid5
+	}
 
-		args = append(args, raid.Devices...)
+	if spares > 0 {
+		args = append(args, "--spare-devices", fmt.Sprintf("%d", spares))
+	}
 
-		f.log.Info("create mdadm raid", "args", args)
-		err := os.ExecuteCommand(command.MDADM, args...)
-		if err != nil {
-			f.log.Error("create mdadm raid", "error", err)
-			return fmt.Errorf("unable to create mdadm raid %s %w", *raid.Arrayname, err)
-		}
+	for _, o := range raid.Createoptions {
+		args = append(args, string(o))
+	}
 
-		// set sync speed
-		err = gos.WriteFile("/proc/sys/dev/raid/speed_limit_min", []byte("200000000"), 0644) // nolint:gosec
-		if err != nil {
-			f.log.Error("unable to set min sync speed, ignoring...", "error", err)
-		}
+	args = append(args, raid.Devices...)
+
+	f.track(kindRaid, *raid.Arrayname, raid.Devices...)
+	f.log.Info("create mdadm raid", "args", args)
+	err := executeCommand(command.MDADM, args...)
+	if err != nil {
+		f.log.Error("create mdadm raid", "error", err)
+		return fmt.Errorf("unable to create mdadm raid %s %w", *raid.Arrayname, err)
+	}
+
+	err = waitForDevices(f.log, settleTimeout, *raid.Arrayname)
+	if err != nil {
+		return err
 	}
+	f.tuneRaidSync(*raid.Arrayname, level)
 	return nil
 }
 
-func (f *Filesystem) createLogicalVolumes() error {
-	if len(f.config.Volumegroups) == 0 {
+func (f *Filesystem) createVolumeGroup(vg *models.V1VolumeGroup) error {
+	if vg.Name == nil || *vg.Name == "" {
 		return nil
 	}
+	f.mu.Lock()
+	f.pvcount[*vg.Name] = len(vg.Devices)
+	f.mu.Unlock()
 
-	pvcount := make(map[string]int)
-	for _, vg := range f.config.Volumegroups {
-		if vg.Name == nil || *vg.Name == "" {
-			continue
-		}
-		if vgExists(f.log, *vg.Name) {
-			continue
-		}
-		args := []string{
-			"vgcreate",
-			"--verbose",
-			*vg.Name,
-		}
-		for _, tag := range vg.Tags {
-			args = append(args, "--addtag", tag)
+	if vgExists(f.log, *vg.Name) {
+		if keptDevices(f.config)[*vg.Name] {
+			f.log.Info("volumegroup already exists and holds a kept filesystem, reuse it", "vg", *vg.Name)
+			return nil
 		}
-		args = append(args, vg.Devices...)
-
-		pvcount[*vg.Name] = len(vg.Devices)
-		err := os.ExecuteCommand(command.LVM, args...)
+		// a leftover of a previous run whose rollback failed, reusing it would keep its stale logical volumes
+		f.log.Warn("volumegroup already exists, remove it", "vg", *vg.Name)
+		err := createdObject{kind: kindVolumeGroup, name: *vg.Name, devices: vg.Devices}.remove()
 		if err != nil {
-			f.log.Error("vgcreate", "error", err)
-			return fmt.Errorf("unable to create volume group %s %w", *vg.Name, err)
+			return fmt.Errorf("unable to remove existing volume group %s %w", *vg.Name, err)
 		}
 	}
+	args := []string{
+		"vgcreate",
+		"--verbose",
+		*vg.Name,
+	}
+	for _, tag := range vg.Tags {
+		args = append(args, "--addtag", tag)
+	}
+	args = append(args, vg.Devices...)
 
-	for _, lv := range f.config.Logicalvolumes {
-		if lv.Name == nil || *lv.Name == "" || lv.Volumegroup == nil || *lv.Volumegroup == "" {
-			continue
-		}
-		if lvExists(f.log, *lv.Volumegroup, *lv.Name) {
-			continue
+	f.track(kindVolumeGroup, *vg.Name, vg.Devices...)
+	err := executeCommand(command.LVM, args...)
+	if err != nil {
+		f.log.Error("vgcreate", "error", err)
+		return fmt.Errorf("unable to create volume group %s %w", *vg.Name, err)
+	}
+	return nil
+}
+
+func (f *Filesystem) createLogicalVolume(lv *models.V1LogicalVolume) error {
+	if lv.Name == nil || *lv.Name == "" || lv.Volumegroup == nil || *lv.Volumegroup == "" {
+		return nil
+	}
+	if lvExists(f.log, *lv.Volumegroup, *lv.Name) {
+		if keptDevices(f.config)[logicalVolumeDevice(*lv.Volumegroup, *lv.Name)] {
+			f.log.Info("logical volume already exists and holds a kept filesystem, reuse it", "lv", *lv.Name, "vg", *lv.Volumegroup)
+			return nil
 		}
-		if lv.Size == nil {
-			continue
+		f.log.Warn("logical volume already exists, remove it", "lv", *lv.Name, "vg", *lv.Volumegroup)
+		err := createdObject{kind: kindLogicalVolume, name: *lv.Volumegroup + "/" + *lv.Name}.remove()
+		if err != nil {
+			return fmt.Errorf("unable to remove existing logical volume %s %w", *lv.Name, err)
 		}
+	}
+	if lv.Size == nil {
+		return nil
+	}
 
-		args := []string{
-			"lvcreate",
-			"--verbose",
-			"--name", *lv.Name,
-			"--wipesignatures", "y",
+	var pvs []string
+	for _, vg := range f.config.Volumegroups {
+		if vg.Name != nil && *vg.Name == *lv.Volumegroup {
+			pvs = vg.Devices
 		}
+	}
 
-		if *lv.Size > int64(0) {
-			args = append(args, "--size", fmt.Sprintf("%dm", *lv.Size))
-		} else {
-			args = append(args, "--extents", "100%FREE")
-		}
+	f.mu.Lock()
+	pvcount := f.pvcount[*lv.Volumegroup]
+	f.mu.Unlock()
 
-		lvmtype := "linear"
-		if lv.Lvmtype != nil {
-			lvmtype = *lv.Lvmtype
-		}
-		if pvcount[*lv.Volumegroup] < 2 {
-			f.log.Warn("volumegroup has only 1 pv, only linear is supported", "lv", *lv.Name, "vg", *lv.Volumegroup)
-			lvmtype = "linear"
-		}
+	lvmtype := lvmType(lv)
+	if pvcount < 2 && (lvmtype == "striped" || lvmtype == "raid1") {
+		f.log.Warn("volumegroup has only 1 pv, only linear is supported", "lv", *lv.Name, "vg", *lv.Volumegroup)
+		lvmtype = "linear"
+	}
+
+	args := []string{
+		"lvcreate",
+		"--verbose",
+		"--name", *lv.Name,
+	}
+	if lvmtype != "thin-pool" {
+		args = append(args, "--wipesignatures", "y")
+	}
 
-		switch lvmtype {
-		case "linear":
-		case "striped":
-			args = append(args, "--type", "striped", "--stripes", fmt.Sprintf("%d", pvcount[*lv.Volumegroup]))
-		case "raid1":
-			args = append(args, "--type", "raid1", "--mirrors", "1", "--nosync")
-		default:
-			return fmt.Errorf("unsupported lvmtype:%s", lvmtype)
+	switch {
+	case lvmtype == "thin":
+		// for thin volumes the size is the virtual size which is allocated on demand from the pool
+		if *lv.Size <= int64(0) {
+			return fmt.Errorf("thin volume %s requires a size", *lv.Name)
 		}
-		args = append(args, *lv.Volumegroup)
+		args = append(args, "--virtualsize", fmt.Sprintf("%dm", *lv.Size))
+	case *lv.Size > int64(0):
+		args = append(args, "--size", fmt.Sprintf("%dm", *lv.Size))
+	default:
+		args = append(args, "--extents", "100%FREE")
+	}
 
-		f.log.Info("lvcreate", "args", args)
-		err := os.ExecuteCommand(command.LVM, args...)
+	typeArgs, placement, err := lvmTypeArgs(lvmtype, *lv.Volumegroup, pvs, f.config.Logicalvolumes)
+	if err != nil {
+		return fmt.Errorf("unable to create logical volume %s %w", *lv.Name, err)
+	}
+	args = append(args, typeArgs...)
+	args = append(args, *lv.Volumegroup)
+	args = append(args, placement...)
+
+	f.track(kindLogicalVolume, *lv.Volumegroup+"/"+*lv.Name)
+	f.log.Info("lvcreate", "args", args)
+	err = executeCommand(command.LVM, args...)
+	if err != nil {
+		f.log.Error("lvcreate", "error", err)
+		return fmt.Errorf("unable to create logical volume %s %w", *lv.Name, err)
+	}
+
+	if lvmtype == "cache" || lvmtype == "writecache" {
+		err = f.attachCache(lvmtype, *lv.Volumegroup, *lv.Name, pvs, cachedVolumes(*lv.Volumegroup, f.config.Logicalvolumes))
 		if err != nil {
-			f.log.Error("lvcreate", "error", err)
-			return fmt.Errorf("unable to create logical volume %s %w", *lv.Name, err)
+			return fmt.Errorf("unable to attach %s to logical volume %s %w", lvmtype, *lv.Name, err)
 		}
 	}
-
-	return nil
+	if lvmtype == "thin-pool" {
+		// thin pools are not activated as a block device
+		return nil
+	}
+	return waitForDevices(f.log, settleTimeout, logicalVolumeDevice(*lv.Volumegroup, *lv.Name))
 }
 
-func (f *Filesystem) createFilesystems() error {
-	if len(f.config.Filesystems) == 0 {
+func (f *Filesystem) createFilesystem(fs *models.V1Filesystem) error {
+	if fs.Format == nil || *fs.Format == "tmpfs" {
 		return nil
 	}
-
-	for _, fs := range f.config.Filesystems {
-		if fs.Format == nil || *fs.Format == "tmpfs" {
-			continue
-		}
-		mkfs := ""
-		args := []string{}
-		args = append(args, fs.Createoptions...)
-		switch *fs.Format {
-		case "ext3":
-			mkfs = command.MKFSExt3
-			args = append(args, "-F")
-			args = append(args, "-L", fs.Label)
-		case "ext4":
-			mkfs = command.MKFSExt4
-			args = append(args, "-F")
-			args = append(args, "-L", fs.Label)
-		case "swap":
-			mkfs = command.MKSwap
-			args = append(args, "-f")
-			args = append(args, "-L", fs.Label)
-		case "vfat":
-			mkfs = command.MKFSVFat
-			// There is no force flag for mkfs.vfat, it always destroys any data on
-			// the device at which it is pointed.
-			args = append(args, "-n", fs.Label)
-		case "none":
-			//
-		default:
-			return fmt.Errorf("unsupported filesystem format: %q", *fs.Format)
-		}
-		args = append(args, *fs.Device)
-		f.log.Info("create filesystem", "args", args)
-		err := os.ExecuteCommand(mkfs, args...)
+	if keepIfPresent(fs) {
+		keep, err := f.keepExistingFilesystem(fs)
 		if err != nil {
-			f.log.Error("create filesystem failed", "device", *fs.Device, "error", err)
-			return fmt.Errorf("unable to create filesystem on %s %w", *fs.Device, err)
+			return err
 		}
+		if keep {
+			f.mu.Lock()
+			f.kept[*fs.Device] = true
+			f.mu.Unlock()
+			return nil
+		}
+	}
+	mkfs := ""
+	args := []string{}
+	args = append(args, fs.Createoptions...)
+	switch *fs.Format {
+	case "ext3":
+		mkfs = command.MKFSExt3
+		args = append(args, "-F")
+		args = append(args, "-L", fs.Label)
+	case "ext4":
+		mkfs = command.MKFSExt4
+		args = append(args, "-F")
+		args = append(args, "-L", fs.Label)
+	case "swap":
+		mkfs = command.MKSwap
+		args = append(args, "-f")
+		args = append(args, "-L", fs.Label)
+	case "vfat":
+		mkfs = command.MKFSVFat
+		// There is no force flag for mkfs.vfat, it always destroys any data on
+		// the device at which it is pointed.
+		args = append(args, "-n", fs.Label)
+	case "none":
+		//
+	default:
+		return fmt.Errorf("unsupported filesystem format: %q", *fs.Format)
+	}
+	args = append(args, *fs.Device)
+	f.track(kindFilesystem, *fs.Device)
+	f.log.Info("create filesystem", "args", args)
+	err := executeCommand(mkfs, args...)
+	if err != nil {
+		f.log.Error("create filesystem failed", "device", *fs.Device, "error", err)
+		return fmt.Errorf("unable to create filesystem on %s %w", *fs.Device, err)
 	}
-
 	return nil
 }
 
@@ -348,7 +431,8 @@ func (f *Filesystem) mountFilesystems() error {
 		}
 		fss = append(fss, *fs)
 	}
-	sort.Slice(fss, func(i, j int) bool { return depth(fss[i].Path) < depth(fss[j].Path) })
+	// stable sort keeps the order of the layout for mounts of the same depth
+	sort.SliceStable(fss, func(i, j int) bool { return depth(fss[i].Path) < depth(fss[j].Path) })
 	for _, fs := range fss {
 		path, err := mountFs(f.log, f.chroot, fs)
 		if err != nil {
@@ -374,10 +458,7 @@ func (f *Filesystem) mountFilesystems() error {
 		if fs.Path == "/" {
 			passno = 1
 		}
-		mountOpts := []string{"defaults"}
-		if len(fs.Mountoptions) > 0 {
-			mountOpts = fs.Mountoptions
-		}
+		mountOpts := fstabMountOptions(fs.Mountoptions)
 		fstabEntry := fstabEntry{
 			spec:      spec,
 			file:      fs.Path,
@@ -442,19 +523,21 @@ func (f *Filesystem) mountSpecialFilesystems() error {
 		if err != nil {
 			return fmt.Errorf("mounting %s to %s failed %w", m.source, m.target, err)
 		}
+		f.specialMounts = append(f.specialMounts, mountPoint)
 	}
 	return nil
 }
 
 func (f *Filesystem) umountFilesystems() {
-	for index := len(specialMounts) - 1; index >= 0; index-- {
-		m := filepath.Join(f.chroot, specialMounts[index].target)
+	for index := len(f.specialMounts) - 1; index >= 0; index-- {
+		m := f.specialMounts[index]
 		f.log.Info("unmounting", "mountpoint", m)
 		err := syscall.Unmount(m, syscall.MNT_FORCE)
 		if err != nil {
 			f.log.Error("unable to unmount", "path", m, "error", err)
 		}
 	}
+	f.specialMounts = nil
 	for index := len(f.mounts) - 1; index >= 0; index-- {
 		m := f.mounts[index]
 		if m == "" {
@@ -512,7 +595,7 @@ func mountFs(log *slog.Logger, chroot string, fs models.V1Filesystem) (string, e
 		args = append(args, "-o", opts)
 	}
 	args = append(args, "-t", *fs.Format, *fs.Device, path)
-	err := os.ExecuteCommand("mount", args...)
+	err := executeCommand("mount", args...)
 	if err != nil {
 		log.Error("mount filesystem failed", "device", *fs.Device, "path", fs.Path, "opts", opts, "error", err)
 		return "", fmt.Errorf("unable to mount filesystem %s on %s opts:%v error:%w", *fs.Device, fs.Path, opts, err)
@@ -549,28 +632,8 @@ func optionSliceToString(opts []string, separator string) string {
 	return strings.Join(mountOpts, separator)
 }
 
-// write all fstab entries to /etc/fstab inside chroot
-func (fss fstabEntries) write(log *slog.Logger, chroot string) error {
-	entries := []string{}
-	for _, fs := range fss {
-		entries = append(entries, fs.string())
-	}
-	fstab := strings.Join(entries, "\n")
-	header := fmt.Sprintf("# created by metal-hammer: %q\n", v.V)
-	content := header + fstab + "\n"
-	log.Info("write fstab", "content", content)
-	//nolint:gosec
-	return gos.WriteFile(path.Join(chroot, "/etc/fstab"), []byte(content), 0644)
-}
-
-func (fs fstabEntry) string() string {
-	return fmt.Sprintf("%s %s %s %s %d %d", fs.spec, fs.file, fs.vfsType, strings.Join(fs.mountOpts, ","), fs.freq, fs.passno)
-}
-
 func lvExists(log *slog.Logger, vg string, name string) bool {
-	//nolint:gosec
-	cmd := exec.Command("lvm", "lvs", vg+"/"+name, "--noheadings", "-o", "lv_name")
-	out, err := cmd.CombinedOutput()
+	out, err := reportOutput(command.LVM, "lvs", vg+"/"+name, "--noheadings", "-o", "lv_name")
 	if err != nil {
 		log.Info("unable to list existing volumes", "lv", name, "error", err)
 		return false
@@ -579,8 +642,7 @@ func lvExists(log *slog.Logger, vg string, name string) bool {
 }
 
 func vgExists(log *slog.Logger, vgname string) bool {
-	cmd := exec.Command("lvm", "vgs", vgname, "--noheadings", "-o", "vg_name")
-	out, err := cmd.CombinedOutput()
+	out, err := reportOutput(command.LVM, "vgs", vgname, "--noheadings", "-o", "vg_name")
 	if err != nil {
 		log.Info("unable to list existing volumegroups", "vg", vgname, "error", err)
 		return false
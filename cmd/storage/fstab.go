@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	gos "os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/metal-stack/v"
+)
+
+const (
+	fstabOriginLayout = "from layout"
+	fstabOriginImage  = "from image"
+)
+
+// write all fstab entries to /etc/fstab inside chroot.
+// Entries of an existing fstab shipped with the image are kept if their mountpoint is not managed by the filesystem layout.
+func (fss fstabEntries) write(log *slog.Logger, chroot string) error {
+	fstabPath := path.Join(chroot, "/etc/fstab")
+
+	var image fstabEntries
+	existing, err := gos.ReadFile(fstabPath)
+	if err == nil {
+		image = parseFstab(string(existing))
+	} else if !gos.IsNotExist(err) {
+		return fmt.Errorf("unable to read existing fstab %w", err)
+	}
+
+	content := fss.merge(image)
+	log.Info("write fstab", "content", content)
+	//nolint:gosec
+	return gos.WriteFile(fstabPath, []byte(content), 0644)
+}
+
+// merge the entries of the filesystem layout with the entries shipped with the image.
+// Layout entries take precedence, duplicates are removed and the origin of every entry is appended as a comment,
+// it stays with the entry if the file is reordered later on.
+func (fss fstabEntries) merge(image fstabEntries) string {
+	var (
+		managed  = map[string]bool{}
+		seen     = map[string]bool{}
+		layout   []string
+		kept     []string
+		replaced []string
+	)
+	for _, fs := range fss {
+		if seen[fs.key()] {
+			continue
+		}
+		seen[fs.key()] = true
+		managed[fs.key()] = true
+		layout = append(layout, fs.string()+" # "+fstabOriginLayout)
+	}
+	for _, fs := range image {
+		if managed[fs.key()] {
+			replaced = append(replaced, "# "+fs.string()+" # "+fstabOriginImage+", replaced by layout")
+			continue
+		}
+		if seen[fs.key()] {
+			continue
+		}
+		seen[fs.key()] = true
+		kept = append(kept, fs.string()+" # "+fstabOriginImage)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# created by metal-hammer: %q\n", v.V)
+	for _, l := range slices.Concat(layout, kept, replaced) {
+		sb.WriteString(l + "\n")
+	}
+	return sb.String()
+}
+
+// key identifies the mountpoint of an entry, swap has no mountpoint and is identified by its device
+func (fs fstabEntry) key() string {
+	if fs.vfsType == "swap" {
+		return "swap:" + fs.spec
+	}
+	return path.Clean(fs.file)
+}
+
+func (fs fstabEntry) string() string {
+	return fmt.Sprintf("%s %s %s %s %d %d", fs.spec, fs.file, fs.vfsType, strings.Join(fs.mountOpts, ","), fs.freq, fs.passno)
+}
+
+// parseFstab parses the content of a fstab, comments and invalid lines are skipped.
+func parseFstab(content string) fstabEntries {
+	entries := fstabEntries{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		entry := fstabEntry{
+			spec:      fields[0],
+			file:      fields[1],
+			vfsType:   fields[2],
+			mountOpts: []string{"defaults"},
+		}
+		if len(fields) > 3 {
+			entry.mountOpts = strings.Split(fields[3], ",")
+		}
+		if len(fields) > 4 {
+			freq, err := strconv.ParseUint(fields[4], 10, 32)
+			if err == nil {
+				entry.freq = uint(freq)
+			}
+		}
+		if len(fields) > 5 {
+			passno, err := strconv.ParseUint(fields[5], 10, 32)
+			if err == nil {
+				entry.passno = uint(passno)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
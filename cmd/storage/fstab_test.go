@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFstabMerge(t *testing.T) {
+	layout := fstabEntries{
+		{spec: "UUID=1", file: "/", vfsType: "ext4", mountOpts: []string{"defaults"}, passno: 1},
+		{spec: "UUID=2", file: "/boot/efi", vfsType: "vfat", mountOpts: []string{"defaults"}},
+		{spec: "UUID=3", file: "none", vfsType: "swap", mountOpts: []string{"sw"}},
+	}
+	image := `# /etc/fstab: static file system information.
+UUID=a / ext4 errors=remount-ro 0 1
+
+proc /proc proc defaults 0 0
+tmpfs /tmp tmpfs size=1G
+tmpfs /tmp/ tmpfs size=2G
+/dev/sdz1 none swap sw 0 0
+invalid
+`
+	got := layout.merge(parseFstab(image))
+	want := []string{
+		"UUID=1 / ext4 defaults 0 1 # from layout",
+		"UUID=2 /boot/efi vfat defaults 0 0 # from layout",
+		"UUID=3 none swap sw 0 0 # from layout",
+		"proc /proc proc defaults 0 0 # from image",
+		"tmpfs /tmp tmpfs size=1G 0 0 # from image",
+		"/dev/sdz1 none swap sw 0 0 # from image",
+		"# UUID=a / ext4 errors=remount-ro 0 1 # from image, replaced by layout",
+	}
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if !reflect.DeepEqual(want, lines[1:]) {
+		t.Errorf("merge() = %v, want %v", lines[1:], want)
+	}
+
+	// a merged fstab is parsed again on reinstall, the origin comments must not become part of the entries
+	wantReparsed := append(layout,
+		fstabEntry{spec: "proc", file: "/proc", vfsType: "proc", mountOpts: []string{"defaults"}},
+		fstabEntry{spec: "tmpfs", file: "/tmp", vfsType: "tmpfs", mountOpts: []string{"size=1G"}},
+		fstabEntry{spec: "/dev/sdz1", file: "none", vfsType: "swap", mountOpts: []string{"sw"}},
+	)
+	if reparsed := parseFstab(got); !reflect.DeepEqual(reparsed, wantReparsed) {
+		t.Errorf("parseFstab() of the merged fstab = %v, want %v", reparsed, wantReparsed)
+	}
+}
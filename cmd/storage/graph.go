@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/metal-stack/metal-go/api/models"
+)
+
+// task creates one storage object, it is started once all tasks it depends on are finished.
+type task struct {
+	name string
+	deps []*task
+	run  func() error
+}
+
+// createStorage creates all partitions, raids, volumegroups, logical volumes and filesystems.
+// Independent objects, e.g. filesystems on different disks, are created in parallel,
+// the dependencies follow disk → partition → raid → vg → lv → fs.
+// Mounting is done afterwards in a deterministic order.
+func (f *Filesystem) createStorage() error {
+	return runTasks(f.log, f.tasks(), f.workers)
+}
+
+func (f *Filesystem) tasks() []*task {
+	var (
+		tasks []*task
+		// producers maps a device to the task which creates it
+		producers = map[string]*task{}
+		vgs       = map[string]*task{}
+		// lastLV of a volumegroup, logical volumes of the same volumegroup are created one after another
+		lastLV = map[string]*task{}
+	)
+	depsOf := func(devices ...string) []*task {
+		var deps []*task
+		for _, d := range devices {
+			if t, ok := producers[d]; ok && !slices.Contains(deps, t) {
+				deps = append(deps, t)
+			}
+		}
+		return deps
+	}
+
+	for _, disk := range f.config.Disks {
+		if disk.Device == nil {
+			continue
+		}
+		t := &task{
+			name: fmt.Sprintf("create partitions on %s", *disk.Device),
+			run:  func() error { return f.createPartitions(disk) },
+		}
+		producers[*disk.Device] = t
+		for _, p := range disk.Partitions {
+			if p.Number != nil {
+				producers[partitionDevice(*disk.Device, *p.Number)] = t
+			}
+		}
+		tasks = append(tasks, t)
+	}
+
+	for _, raid := range f.config.Raid {
+		if raid.Arrayname == nil {
+			continue
+		}
+		t := &task{
+			name: fmt.Sprintf("create raid %s", *raid.Arrayname),
+			deps: depsOf(raid.Devices...),
+			run:  func() error { return f.createRaid(raid) },
+		}
+		producers[*raid.Arrayname] = t
+		tasks = append(tasks, t)
+	}
+
+	for _, vg := range f.config.Volumegroups {
+		if vg.Name == nil || *vg.Name == "" {
+			continue
+		}
+		t := &task{
+			name: fmt.Sprintf("create volumegroup %s", *vg.Name),
+			deps: depsOf(vg.Devices...),
+			run:  func() error { return f.createVolumeGroup(vg) },
+		}
+		vgs[*vg.Name] = t
+		tasks = append(tasks, t)
+	}
+
+	// thin volumes require their pool to be present, create them last
+	lvs := slices.Clone(f.config.Logicalvolumes)
+	slices.SortStableFunc(lvs, func(a, b *models.V1LogicalVolume) int {
+		return lvmTypePriority(a) - lvmTypePriority(b)
+	})
+	for _, lv := range lvs {
+		if lv.Name == nil || *lv.Name == "" || lv.Volumegroup == nil || *lv.Volumegroup == "" {
+			continue
+		}
+		vg := *lv.Volumegroup
+		t := &task{
+			name: fmt.Sprintf("create logical volume %s/%s", vg, *lv.Name),
+			run:  func() error { return f.createLogicalVolume(lv) },
+		}
+		if dep, ok := vgs[vg]; ok {
+			t.deps = append(t.deps, dep)
+		}
+		if dep, ok := lastLV[vg]; ok {
+			t.deps = append(t.deps, dep)
+		}
+		lastLV[vg] = t
+		producers[logicalVolumeDevice(vg, *lv.Name)] = t
+		producers[filepath.Join("/dev", "mapper", strings.ReplaceAll(vg, "-", "--")+"-"+strings.ReplaceAll(*lv.Name, "-", "--"))] = t
+		tasks = append(tasks, t)
+	}
+
+	for _, fs := range f.config.Filesystems {
+		if fs.Format == nil || *fs.Format == "tmpfs" || fs.Device == nil {
+			continue
+		}
+		tasks = append(tasks, &task{
+			name: fmt.Sprintf("create filesystem on %s", *fs.Device),
+			deps: depsOf(*fs.Device),
+			run:  func() error { return f.createFilesystem(fs) },
+		})
+	}
+
+	return tasks
+}
+
+// runTasks executes the tasks with at most workers in parallel, tasks are started in the given order once their dependencies are done.
+// After the first failure no further tasks are started, running tasks are waited for and all errors are returned.
+func runTasks(log *slog.Logger, tasks []*task, workers int) error {
+	type result struct {
+		task     *task
+		err      error
+		duration time.Duration
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		results = make(chan result)
+		started = map[*task]bool{}
+		done    = map[*task]bool{}
+		running int
+		errs    []error
+	)
+
+	ready := func(t *task) bool {
+		for _, dep := range t.deps {
+			if !done[dep] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for {
+		for _, t := range tasks {
+			if len(errs) > 0 || running >= workers {
+				break
+			}
+			if started[t] || !ready(t) {
+				continue
+			}
+			started[t] = true
+			running++
+			log.Info("start", "task", t.name)
+			go func() {
+				start := time.Now()
+				err := t.run()
+				results <- result{task: t, err: err, duration: time.Since(start)}
+			}()
+		}
+
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+		done[r.task] = true
+		if r.err != nil {
+			log.Error("failed", "task", r.task.name, "took", r.duration, "error", r.err)
+			errs = append(errs, fmt.Errorf("%s failed:%w", r.task.name, r.err))
+			continue
+		}
+		log.Info("finished", "task", r.task.name, "took", r.duration)
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if len(done) != len(tasks) {
+		return fmt.Errorf("unable to resolve dependencies, only %d of %d tasks were executed", len(done), len(tasks))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunTasks(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		order []string
+		// parallel is the number of concurrently running tasks
+		parallel    atomic.Int32
+		maxParallel atomic.Int32
+	)
+	newTask := func(name string, err error, deps ...*task) *task {
+		return &task{
+			name: name,
+			deps: deps,
+			run: func() error {
+				p := parallel.Add(1)
+				defer parallel.Add(-1)
+				if p > maxParallel.Load() {
+					maxParallel.Store(p)
+				}
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				return err
+			},
+		}
+	}
+
+	sda := newTask("sda", nil)
+	sdb := newTask("sdb", nil)
+	sdc := newTask("sdc", nil)
+	md1 := newTask("md1", nil, sda, sdb)
+	vg := newTask("vg", nil, md1)
+	lv := newTask("lv", nil, vg)
+	fs1 := newTask("fs1", nil, lv)
+	fs2 := newTask("fs2", nil, sdc)
+
+	err := runTasks(slog.Default(), []*task{sda, sdb, sdc, md1, vg, lv, fs1, fs2}, 2)
+	if err != nil {
+		t.Errorf("runTasks() unexpected error %v", err)
+	}
+	if len(order) != 8 {
+		t.Errorf("runTasks() executed %d tasks, want 8", len(order))
+	}
+	before := func(a, b string) bool {
+		return slices.Index(order, a) < slices.Index(order, b)
+	}
+	for _, dep := range [][2]string{{"sda", "md1"}, {"sdb", "md1"}, {"md1", "vg"}, {"vg", "lv"}, {"lv", "fs1"}, {"sdc", "fs2"}} {
+		if !before(dep[0], dep[1]) {
+			t.Errorf("runTasks() executed %s before %s: %v", dep[1], dep[0], order)
+		}
+	}
+	if maxParallel.Load() > 2 {
+		t.Errorf("runTasks() executed %d tasks in parallel, want at most 2", maxParallel.Load())
+	}
+
+	order = nil
+	failing := newTask("failing", errors.New("mkfs failed"))
+	dependent := newTask("dependent", nil, failing)
+	err = runTasks(slog.Default(), []*task{failing, dependent}, 2)
+	if err == nil || err.Error() != "failing failed:mkfs failed" {
+		t.Errorf("runTasks() error = %v, want failing failed:mkfs failed", err)
+	}
+	if slices.Contains(order, "dependent") {
+		t.Errorf("runTasks() executed a task after a failure: %v", order)
+	}
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/metal-stack/metal-go/api/models"
+	"github.com/metal-stack/metal-hammer/pkg/os/command"
+)
+
+// keepMountOption marks a filesystem which must not be formatted if it is already present with the expected format and label,
+// e.g. to preserve /var/lib during a reinstall. With x-metal.keep=<uuid> the existing filesystem must have this uuid instead
+// of the label. Like all x-* options it is ignored by mount.
+const keepMountOption = "x-metal.keep"
+
+// keptDevices returns the devices and volumegroup names below filesystems marked with x-metal.keep,
+// they hold the data to preserve and must not be removed.
+func keptDevices(config models.V1FilesystemLayoutResponse) map[string]bool {
+	kept := map[string]bool{}
+	for _, fs := range config.Filesystems {
+		if fs.Device != nil && keepIfPresent(fs) {
+			kept[*fs.Device] = true
+		}
+	}
+	// walk down the stack lv → vg → raid → partition → disk
+	for _, lv := range config.Logicalvolumes {
+		if lv.Name != nil && lv.Volumegroup != nil && kept[logicalVolumeDevice(*lv.Volumegroup, *lv.Name)] {
+			kept[*lv.Volumegroup] = true
+		}
+	}
+	for _, vg := range config.Volumegroups {
+		if vg.Name != nil && kept[*vg.Name] {
+			for _, d := range vg.Devices {
+				kept[d] = true
+			}
+		}
+	}
+	for _, raid := range config.Raid {
+		if raid.Arrayname != nil && kept[*raid.Arrayname] {
+			for _, d := range raid.Devices {
+				kept[d] = true
+			}
+		}
+	}
+	for _, disk := range config.Disks {
+		if disk.Device == nil {
+			continue
+		}
+		for _, p := range disk.Partitions {
+			if p.Number != nil && kept[partitionDevice(*disk.Device, *p.Number)] {
+				kept[*disk.Device] = true
+			}
+		}
+	}
+	return kept
+}
+
+func keepIfPresent(fs *models.V1Filesystem) bool {
+	return slices.ContainsFunc(fs.Mountoptions, isKeepMountOption)
+}
+
+func isKeepMountOption(o string) bool {
+	return o == keepMountOption || strings.HasPrefix(o, keepMountOption+"=")
+}
+
+// keepUUID returns the uuid given with x-metal.keep=<uuid>, empty if the filesystem is matched by its label
+func keepUUID(fs *models.V1Filesystem) string {
+	for _, o := range fs.Mountoptions {
+		if uuid, ok := strings.CutPrefix(o, keepMountOption+"="); ok {
+			return uuid
+		}
+	}
+	return ""
+}
+
+// keepMatches returns true if the blkid properties of the existing filesystem match the format
+// and either the uuid given with the keep option or the label of the layout
+func keepMatches(props map[string]string, fs *models.V1Filesystem) bool {
+	if props["TYPE"] != *fs.Format {
+		return false
+	}
+	if uuid := keepUUID(fs); uuid != "" {
+		return strings.EqualFold(props["UUID"], uuid)
+	}
+	return strings.EqualFold(props["LABEL"], fs.Label)
+}
+
+// keepExistingFilesystem returns true if the filesystem is already present on the device and was checked successfully,
+// in this case it must not be formatted.
+func (f *Filesystem) keepExistingFilesystem(fs *models.V1Filesystem) (bool, error) {
+	props, err := FetchBlockIDProperties(*fs.Device)
+	if err != nil {
+		// blkid exits with an error if no filesystem was found
+		f.log.Info("no existing filesystem found, format", "device", *fs.Device, "error", err)
+		return false, nil
+	}
+	if !keepMatches(props, fs) {
+		f.log.Warn("existing filesystem does not match the layout, format", "device", *fs.Device, "type", props["TYPE"], "label", props["LABEL"], "uuid", props["UUID"],
+			"expected type", *fs.Format, "expected label", fs.Label, "expected uuid", keepUUID(fs))
+		return false, nil
+	}
+
+	err = checkFilesystem(*fs.Format, *fs.Device)
+	if err != nil {
+		return false, fmt.Errorf("existing filesystem on %s is damaged %w", *fs.Device, err)
+	}
+	f.log.Info("keep existing filesystem", "device", *fs.Device, "uuid", props["UUID"], "label", props["LABEL"], "path", fs.Path)
+	return true, nil
+}
+
+// checkFilesystem runs a filesystem check and repairs errors which can be repaired without interaction
+func checkFilesystem(format, device string) error {
+	switch format {
+	case "ext3", "ext4":
+	default:
+		return nil
+	}
+
+	err := executeCommand(command.E2FSCK, "-f", "-p", device)
+	var exitErr *exec.ExitError
+	// see man e2fsck: 1 errors were corrected, 2 errors were corrected and the system should be rebooted
+	if errors.As(err, &exitErr) && exitErr.ExitCode() <= 2 {
+		return nil
+	}
+	return err
+}
+
+// fstabMountOptions returns the mount options for fstab without the options only used by metal-hammer
+func fstabMountOptions(opts []string) []string {
+	var result []string
+	for _, o := range opts {
+		if isKeepMountOption(o) {
+			continue
+		}
+		result = append(result, o)
+	}
+	if len(result) == 0 {
+		return []string{"defaults"}
+	}
+	return result
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/metal-stack/metal-go/api/models"
+)
+
+func TestKeepMatches(t *testing.T) {
+	ext4 := "ext4"
+	tests := []struct {
+		name         string
+		props        map[string]string
+		mountoptions []string
+		want         bool
+		wantKeep     bool
+	}{
+		{
+			name:         "label matches",
+			props:        map[string]string{"TYPE": "ext4", "LABEL": "VARLIB", "UUID": "0b5c1b0e-6a3c-4c5e-9d43-6f0f3c2d9a11"},
+			mountoptions: []string{"noatime", "x-metal.keep"},
+			want:         true,
+			wantKeep:     true,
+		},
+		{
+			name:         "label differs",
+			props:        map[string]string{"TYPE": "ext4", "LABEL": "data"},
+			mountoptions: []string{"x-metal.keep"},
+			want:         false,
+			wantKeep:     true,
+		},
+		{
+			name:         "format differs",
+			props:        map[string]string{"TYPE": "xfs", "LABEL": "varlib"},
+			mountoptions: []string{"x-metal.keep"},
+			want:         false,
+			wantKeep:     true,
+		},
+		{
+			name:         "uuid matches with different label",
+			props:        map[string]string{"TYPE": "ext4", "LABEL": "data", "UUID": "0b5c1b0e-6a3c-4c5e-9d43-6f0f3c2d9a11"},
+			mountoptions: []string{"x-metal.keep=0B5C1B0E-6A3C-4C5E-9D43-6F0F3C2D9A11"},
+			want:         true,
+			wantKeep:     true,
+		},
+		{
+			name:         "uuid differs with matching label",
+			props:        map[string]string{"TYPE": "ext4", "LABEL": "varlib", "UUID": "7d2e4f55-1c1a-4b7e-8f0e-2a9c5d3b6e20"},
+			mountoptions: []string{"x-metal.keep=0b5c1b0e-6a3c-4c5e-9d43-6f0f3c2d9a11"},
+			want:         false,
+			wantKeep:     true,
+		},
+		{
+			name:         "not kept",
+			props:        map[string]string{"TYPE": "ext4", "LABEL": "varlib"},
+			mountoptions: []string{"noatime", "x-metal.keeper"},
+			want:         true,
+			wantKeep:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &models.V1Filesystem{Format: &ext4, Label: "varlib", Mountoptions: tt.mountoptions}
+			if got := keepIfPresent(fs); got != tt.wantKeep {
+				t.Errorf("keepIfPresent() = %v, want %v", got, tt.wantKeep)
+			}
+			if got := keepMatches(tt.props, fs); got != tt.want {
+				t.Errorf("keepMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckFilesystem(t *testing.T) {
+	exitError := func(code string) error {
+		return exec.Command("sh", "-c", "exit "+code).Run()
+	}
+	tests := []struct {
+		name     string
+		format   string
+		err      error
+		wantArgs []string
+		wantErr  bool
+	}{
+		{
+			name:     "clean",
+			format:   "ext4",
+			wantArgs: []string{"e2fsck", "-f", "-p", "/dev/vg00/varlib"},
+		},
+		{
+			name:     "errors corrected",
+			format:   "ext4",
+			err:      exitError("1"),
+			wantArgs: []string{"e2fsck", "-f", "-p", "/dev/vg00/varlib"},
+		},
+		{
+			name:     "uncorrected errors",
+			format:   "ext3",
+			err:      exitError("4"),
+			wantArgs: []string{"e2fsck", "-f", "-p", "/dev/vg00/varlib"},
+			wantErr:  true,
+		},
+		{
+			name:     "e2fsck not found",
+			format:   "ext4",
+			err:      errors.New("unable to locate program:e2fsck in path"),
+			wantArgs: []string{"e2fsck", "-f", "-p", "/dev/vg00/varlib"},
+			wantErr:  true,
+		},
+		{
+			name:   "no check for other formats",
+			format: "xfs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			original := executeCommand
+			defer func() { executeCommand = original }()
+			executeCommand = func(name string, arg ...string) error {
+				args = append([]string{name}, arg...)
+				return tt.err
+			}
+
+			err := checkFilesystem(tt.format, "/dev/vg00/varlib")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkFilesystem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("checkFilesystem() executed %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestFstabMountOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []string
+		want []string
+	}{
+		{
+			name: "no options",
+			want: []string{"defaults"},
+		},
+		{
+			name: "only keep",
+			opts: []string{"x-metal.keep"},
+			want: []string{"defaults"},
+		},
+		{
+			name: "keep by uuid",
+			opts: []string{"noatime", "x-metal.keep=0b5c1b0e-6a3c-4c5e-9d43-6f0f3c2d9a11", "nodev"},
+			want: []string{"noatime", "nodev"},
+		},
+		{
+			name: "other options are kept",
+			opts: []string{"noatime", "x-metal.keep", "x-systemd.automount"},
+			want: []string{"noatime", "x-systemd.automount"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fstabMountOptions(tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fstabMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeptDevices(t *testing.T) {
+	ext4 := "ext4"
+	vg00 := "vg00"
+	varlib := "varlib"
+	root := "root"
+	md0 := "/dev/md0"
+	sda := "/dev/sda"
+	sdb := "/dev/sdb"
+	nvme := "/dev/nvme0n1"
+	one := int64(1)
+	varlibDevice := "/dev/vg00/varlib"
+	rootDevice := "/dev/nvme0n1p1"
+	config := models.V1FilesystemLayoutResponse{
+		Disks: []*models.V1Disk{
+			{Device: &sda, Partitions: []*models.V1DiskPartition{{Number: &one}}},
+			{Device: &sdb, Partitions: []*models.V1DiskPartition{{Number: &one}}},
+			{Device: &nvme, Partitions: []*models.V1DiskPartition{{Number: &one}}},
+		},
+		Raid:           []*models.V1Raid{{Arrayname: &md0, Devices: []string{"/dev/sda1", "/dev/sdb1"}}},
+		Volumegroups:   []*models.V1VolumeGroup{{Name: &vg00, Devices: []string{md0}}},
+		Logicalvolumes: []*models.V1LogicalVolume{{Name: &varlib, Volumegroup: &vg00}, {Name: &root, Volumegroup: &vg00}},
+		Filesystems: []*models.V1Filesystem{
+			{Device: &varlibDevice, Format: &ext4, Mountoptions: []string{"x-metal.keep"}},
+			{Device: &rootDevice, Format: &ext4},
+		},
+	}
+	want := map[string]bool{
+		varlibDevice: true,
+		vg00:         true,
+		md0:          true,
+		"/dev/sda1":  true,
+		"/dev/sdb1":  true,
+		sda:          true,
+		sdb:          true,
+	}
+	if got := keptDevices(config); !reflect.DeepEqual(got, want) {
+		t.Errorf("keptDevices() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	gos "os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/jaypipes/ghw"
+	"github.com/metal-stack/metal-hammer/pkg/api"
+	"github.com/metal-stack/metal-hammer/pkg/os/command"
+)
+
+// createLayoutJSON writes /etc/metal/layout.json which describes the created storage
+// with all identifiers required to find the devices again.
+func (f *Filesystem) createLayoutJSON() error {
+	layout := f.layout()
+
+	configdir := path.Join(f.chroot, "etc", "metal")
+	err := gos.MkdirAll(configdir, 0755)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.MarshalIndent(layout, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal to json %w", err)
+	}
+	f.log.Info("create layout.json", "content", string(j))
+	return gos.WriteFile(path.Join(configdir, "layout.json"), j, 0600)
+}
+
+// layout collects the actual state of all objects of the filesystem layout,
+// properties which can not be read are left empty.
+func (f *Filesystem) layout() api.Layout {
+	layout := api.Layout{
+		Version:        api.LayoutVersion,
+		ID:             layoutName(f.config),
+		Disks:          []api.LayoutDisk{},
+		Raids:          []api.LayoutRaid{},
+		VolumeGroups:   []api.LayoutVolumeGroup{},
+		LogicalVolumes: []api.LayoutLogicalVolume{},
+		Filesystems:    []api.LayoutFilesystem{},
+	}
+
+	hardware := map[string]*ghw.Disk{}
+	block, err := ghw.Block()
+	if err != nil {
+		f.log.Warn("unable to gather disks, serials are missing in layout.json", "error", err)
+	} else {
+		for _, disk := range block.Disks {
+			hardware["/dev/"+disk.Name] = disk
+		}
+	}
+
+	for _, disk := range f.config.Disks {
+		if disk.Device == nil {
+			continue
+		}
+		d := api.LayoutDisk{
+			Device:         *disk.Device,
+			PartitionTable: partitionTableType(disk),
+			Partitions:     []api.LayoutPartition{},
+		}
+		if hw, ok := hardware[*disk.Device]; ok {
+			d.Serial = hw.SerialNumber
+			d.WWN = hw.WWN
+			d.Model = hw.Model
+		}
+		d.Size, _ = sysfsDeviceSize(*disk.Device)
+
+		for _, p := range disk.Partitions {
+			if p.Number == nil {
+				continue
+			}
+			device := partitionDevice(*disk.Device, *p.Number)
+			part := api.LayoutPartition{
+				Device: device,
+				Number: *p.Number,
+				Label:  p.Label,
+			}
+			if p.Gpttype != nil {
+				part.GPTType = *p.Gpttype
+			}
+			part.Size, _ = sysfsDeviceSize(device)
+			props, err := FetchBlockIDProperties(device)
+			if err != nil {
+				f.log.Warn("unable to read partition properties", "device", device, "error", err)
+			} else {
+				part.PartUUID = props["PARTUUID"]
+			}
+			d.Partitions = append(d.Partitions, part)
+		}
+		layout.Disks = append(layout.Disks, d)
+	}
+
+	for _, raid := range f.config.Raid {
+		if raid.Arrayname == nil {
+			continue
+		}
+		r := api.LayoutRaid{
+			Device:  *raid.Arrayname,
+			Devices: raid.Devices,
+		}
+		if raid.Spares != nil {
+			r.Spares = *raid.Spares
+		}
+		props, err := mdadmDetail(*raid.Arrayname)
+		if err != nil {
+			f.log.Warn("unable to read raid properties", "device", *raid.Arrayname, "error", err)
+		} else {
+			r.Level = props["MD_LEVEL"]
+			r.UUID = props["MD_UUID"]
+		}
+		layout.Raids = append(layout.Raids, r)
+	}
+
+	for _, vg := range f.config.Volumegroups {
+		if vg.Name == nil || *vg.Name == "" {
+			continue
+		}
+		uuid, err := lvmReport("vgs", *vg.Name, "vg_uuid")
+		if err != nil {
+			f.log.Warn("unable to read volumegroup properties", "vg", *vg.Name, "error", err)
+		}
+		layout.VolumeGroups = append(layout.VolumeGroups, api.LayoutVolumeGroup{
+			Name:    *vg.Name,
+			UUID:    uuid,
+			Devices: vg.Devices,
+			Tags:    vg.Tags,
+		})
+	}
+
+	for _, lv := range f.config.Logicalvolumes {
+		if lv.Name == nil || *lv.Name == "" || lv.Volumegroup == nil || *lv.Volumegroup == "" {
+			continue
+		}
+		l := api.LayoutLogicalVolume{
+			Name:        *lv.Name,
+			VolumeGroup: *lv.Volumegroup,
+			Device:      logicalVolumeDevice(*lv.Volumegroup, *lv.Name),
+			Type:        lvmType(lv),
+		}
+		uuid, err := lvmReport("lvs", *lv.Volumegroup+"/"+*lv.Name, "lv_uuid")
+		if err != nil {
+			f.log.Warn("unable to read logical volume properties", "lv", l.Device, "error", err)
+		}
+		l.UUID = uuid
+		l.Size, _ = sysfsDeviceSize(l.Device)
+		layout.LogicalVolumes = append(layout.LogicalVolumes, l)
+	}
+
+	for _, fs := range f.config.Filesystems {
+		if fs.Format == nil {
+			continue
+		}
+		l := api.LayoutFilesystem{
+			Path:         fs.Path,
+			Format:       *fs.Format,
+			Label:        fs.Label,
+			MountOptions: fstabMountOptions(fs.Mountoptions),
+		}
+		if fs.Device != nil && *fs.Format != "tmpfs" {
+			l.Device = *fs.Device
+			l.Kept = f.kept[*fs.Device]
+			props, err := FetchBlockIDProperties(*fs.Device)
+			if err != nil {
+				f.log.Warn("unable to read filesystem properties", "device", *fs.Device, "error", err)
+			} else {
+				l.UUID = props["UUID"]
+			}
+		}
+		layout.Filesystems = append(layout.Filesystems, l)
+	}
+
+	return layout
+}
+
+// reportOutput runs a command which only reports properties and returns its standard output,
+// warnings on stderr must not end up in the properties and are only part of the error.
+// Tests replace it to return a recorded output.
+var reportOutput = func(name string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to locate program:%s in path %w", name, err)
+	}
+	out, err := exec.Command(path, args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return out, fmt.Errorf("stderr:%s %w", strings.TrimSpace(string(exitErr.Stderr)), err)
+	}
+	return out, err
+}
+
+// mdadmDetail returns the properties of the given md array
+func mdadmDetail(device string) (map[string]string, error) {
+	out, err := reportOutput(command.MDADM, "--detail", "--export", device)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute %s --detail %s output:%s %w", command.MDADM, device, out, err)
+	}
+
+	// output of
+	// mdadm --detail --export /dev/md1:
+	//
+	// MD_LEVEL=raid1
+	// MD_DEVICES=2
+	// MD_METADATA=1.2
+	// MD_UUID=2e4d5be8:54f2a7e6:d6a8f9e8:1c8dc5a4
+	// MD_NAME=any:1
+	props := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		props[key] = value
+	}
+	return props, nil
+}
+
+// lvmReport returns a single field of a vg or lv with the given lvm reporting command, e.g. vgs or lvs
+func lvmReport(report, name, field string) (string, error) {
+	out, err := reportOutput(command.LVM, report, name, "--noheadings", "-o", field)
+	if err != nil {
+		return "", fmt.Errorf("unable to execute %s %s %s output:%s %w", command.LVM, report, name, out, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
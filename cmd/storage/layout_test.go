@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/metal-stack/metal-go/api/models"
+	"github.com/metal-stack/metal-hammer/pkg/api"
+)
+
+func TestLayout(t *testing.T) {
+	outputs := map[string]string{
+		"mdadm --detail --export /dev/md1":             "MD_LEVEL=raid1\nMD_DEVICES=2\nMD_METADATA=1.2\nMD_UUID=2e4d5be8:54f2a7e6:d6a8f9e8:1c8dc5a4\nMD_NAME=any:1\n",
+		"lvm vgs vg00 --noheadings -o vg_uuid":         "  Xq3gN2-0bKv-8kXl-ePf1-QYxw-7mRt-2uHc9d\n",
+		"lvm lvs vg00/varlib --noheadings -o lv_uuid":  "  hT7cKp-3WbR-Lx0m-4aYd-Nq8e-Zs1v-6GfJ2o\n",
+		"blkid -o export /dev/vg00/varlib":             "DEVNAME=/dev/vg00/varlib\nUUID=0b5c1b0e-6a3c-4c5e-9d43-6f0f3c2d9a11\nTYPE=ext4\nLABEL=varlib\n",
+		"lvm lvs vg00/missing --noheadings -o lv_uuid": "",
+	}
+	var executed []string
+	original := reportOutput
+	defer func() { reportOutput = original }()
+	reportOutput = func(name string, args ...string) ([]byte, error) {
+		cmd := strings.Join(append([]string{name}, args...), " ")
+		executed = append(executed, cmd)
+		out, ok := outputs[cmd]
+		if !ok {
+			t.Errorf("unexpected command %q", cmd)
+		}
+		if out == "" {
+			return nil, errors.New("exit status 1")
+		}
+		return []byte(out), nil
+	}
+
+	var (
+		id        = "c1-large-x86"
+		md1       = "/dev/md1"
+		spares    = int32(1)
+		vg00      = "vg00"
+		varlib    = "varlib"
+		missing   = "missing"
+		ext4      = "ext4"
+		tmpfs     = "tmpfs"
+		varlibDev = "/dev/vg00/varlib"
+	)
+	f := &Filesystem{
+		log: slog.Default(),
+		config: models.V1FilesystemLayoutResponse{
+			ID: &id,
+			Raid: []*models.V1Raid{
+				{Arrayname: &md1, Devices: []string{"/dev/sda2", "/dev/sdb2"}, Spares: &spares},
+			},
+			Volumegroups: []*models.V1VolumeGroup{
+				{Name: &vg00, Devices: []string{md1}, Tags: []string{"root"}},
+			},
+			Logicalvolumes: []*models.V1LogicalVolume{
+				{Name: &varlib, Volumegroup: &vg00},
+				{Name: &missing, Volumegroup: &vg00},
+			},
+			Filesystems: []*models.V1Filesystem{
+				{Device: &varlibDev, Path: "/var/lib", Format: &ext4, Label: "varlib", Mountoptions: []string{"noatime", "x-metal.keep"}},
+				{Path: "/tmp", Format: &tmpfs, Mountoptions: []string{"size=1G"}},
+			},
+		},
+		kept: map[string]bool{varlibDev: true},
+	}
+
+	got := f.layout()
+	want := api.Layout{
+		Version: api.LayoutVersion,
+		ID:      id,
+		Disks:   []api.LayoutDisk{},
+		Raids: []api.LayoutRaid{
+			{Device: md1, Level: "raid1", UUID: "2e4d5be8:54f2a7e6:d6a8f9e8:1c8dc5a4", Devices: []string{"/dev/sda2", "/dev/sdb2"}, Spares: 1},
+		},
+		VolumeGroups: []api.LayoutVolumeGroup{
+			{Name: vg00, UUID: "Xq3gN2-0bKv-8kXl-ePf1-QYxw-7mRt-2uHc9d", Devices: []string{md1}, Tags: []string{"root"}},
+		},
+		LogicalVolumes: []api.LayoutLogicalVolume{
+			{Name: varlib, VolumeGroup: vg00, Device: varlibDev, UUID: "hT7cKp-3WbR-Lx0m-4aYd-Nq8e-Zs1v-6GfJ2o", Type: "linear"},
+			// properties which can not be read are left empty
+			{Name: missing, VolumeGroup: vg00, Device: "/dev/vg00/missing", Type: "linear"},
+		},
+		Filesystems: []api.LayoutFilesystem{
+			{Device: varlibDev, Path: "/var/lib", Format: ext4, Label: "varlib", UUID: "0b5c1b0e-6a3c-4c5e-9d43-6f0f3c2d9a11", MountOptions: []string{"noatime"}, Kept: true},
+			{Path: "/tmp", Format: tmpfs, MountOptions: []string{"size=1G"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("layout() = %+v, want %+v", got, want)
+	}
+
+	wantExecuted := []string{
+		"mdadm --detail --export /dev/md1",
+		"lvm vgs vg00 --noheadings -o vg_uuid",
+		"lvm lvs vg00/varlib --noheadings -o lv_uuid",
+		"lvm lvs vg00/missing --noheadings -o lv_uuid",
+		"blkid -o export /dev/vg00/varlib",
+	}
+	if !reflect.DeepEqual(executed, wantExecuted) {
+		t.Errorf("layout() executed %v, want %v", executed, wantExecuted)
+	}
+}
+
+func TestReportOutput(t *testing.T) {
+	out, err := reportOutput("sh", "-c", "echo '  WARNING: Not using device /dev/sdc for PV' >&2; echo '  hT7cKp-3WbR-Lx0m-4aYd-Nq8e-Zs1v-6GfJ2o'")
+	if err != nil {
+		t.Fatalf("reportOutput() error = %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "hT7cKp-3WbR-Lx0m-4aYd-Nq8e-Zs1v-6GfJ2o" {
+		t.Errorf("reportOutput() = %q, want only the standard output", got)
+	}
+
+	_, err = reportOutput("sh", "-c", "echo 'Volume group \"vg01\" not found' >&2; exit 5")
+	if err == nil || !strings.Contains(err.Error(), `Volume group "vg01" not found`) {
+		t.Errorf("reportOutput() error = %v, want the standard error", err)
+	}
+}
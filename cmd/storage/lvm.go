@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/metal-stack/metal-go/api/models"
+	"github.com/metal-stack/metal-hammer/pkg/os/command"
+)
+
+// lvmMinimumPVs is the minimum number of physical volumes in the volumegroup for a given lvmtype.
+// striped and raid1 fall back to linear on a single pv for backwards compatibility.
+// cache and writecache require at least one nvme pv for the cache and one other pv for the data.
+var lvmMinimumPVs = map[string]int{
+	"linear":     1,
+	"striped":    1,
+	"raid1":      1,
+	"raid5":      3,
+	"raid6":      5,
+	"raid10":     4,
+	"thin-pool":  1,
+	"thin":       1,
+	"cache":      2,
+	"writecache": 2,
+}
+
+// cacheMetadataHeadroom is the percentage of the cache pvs which is left free for the cache metadata
+const cacheMetadataHeadroom = 1
+
+func lvmType(lv *models.V1LogicalVolume) string {
+	if lv.Lvmtype == nil || *lv.Lvmtype == "" {
+		return "linear"
+	}
+	return *lv.Lvmtype
+}
+
+// lvmTypePriority defines the creation order, thin volumes require an existing thin-pool
+func lvmTypePriority(lv *models.V1LogicalVolume) int {
+	if lvmType(lv) == "thin" {
+		return 1
+	}
+	return 0
+}
+
+// lvmTypeArgs returns the lvcreate arguments for the given lvmtype and the pvs to which the allocation is restricted
+func lvmTypeArgs(lvmtype, vg string, pvs []string, lvs []*models.V1LogicalVolume) ([]string, []string, error) {
+	switch lvmtype {
+	case "linear":
+		return nil, nil, nil
+	case "striped":
+		return []string{"--type", "striped", "--stripes", fmt.Sprintf("%d", len(pvs))}, nil, nil
+	case "raid1":
+		return []string{"--type", "raid1", "--mirrors", "1", "--nosync"}, nil, nil
+	case "raid5":
+		return []string{"--type", "raid5", "--stripes", fmt.Sprintf("%d", len(pvs)-1)}, nil, nil
+	case "raid6":
+		return []string{"--type", "raid6", "--stripes", fmt.Sprintf("%d", len(pvs)-2)}, nil, nil
+	case "raid10":
+		return []string{"--type", "raid10", "--mirrors", "1", "--stripes", fmt.Sprintf("%d", len(pvs)/2), "--nosync"}, nil, nil
+	case "thin-pool":
+		return []string{"--type", "thin-pool"}, nil, nil
+	case "thin":
+		pool, err := thinPool(vg, lvs)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{"--type", "thin", "--thinpool", pool}, nil, nil
+	case "cache", "writecache":
+		// the data is placed on the slow pvs, the cache is attached afterwards on the nvme pvs
+		_, slow := splitCachePVs(pvs)
+		if len(slow) == 0 {
+			return nil, nil, fmt.Errorf("volumegroup %s has no pvs for the data of a %s volume", vg, lvmtype)
+		}
+		return nil, slow, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported lvmtype:%s", lvmtype)
+	}
+}
+
+// thinPool returns the name of the only thin-pool in the given volumegroup
+func thinPool(vg string, lvs []*models.V1LogicalVolume) (string, error) {
+	var pools []string
+	for _, lv := range lvs {
+		if lv.Name == nil || lv.Volumegroup == nil || *lv.Volumegroup != vg {
+			continue
+		}
+		if lvmType(lv) == "thin-pool" {
+			pools = append(pools, *lv.Name)
+		}
+	}
+	if len(pools) != 1 {
+		return "", fmt.Errorf("volumegroup %s must contain exactly one thin-pool for thin volumes, got %d", vg, len(pools))
+	}
+	return pools[0], nil
+}
+
+// cachedVolumes returns the number of cache and writecache volumes in the given volumegroup
+func cachedVolumes(vg string, lvs []*models.V1LogicalVolume) int {
+	count := 0
+	for _, lv := range lvs {
+		if lv.Volumegroup == nil || *lv.Volumegroup != vg {
+			continue
+		}
+		switch lvmType(lv) {
+		case "cache", "writecache":
+			count++
+		}
+	}
+	return count
+}
+
+// splitCachePVs separates the nvme pvs which are used for caches from the others
+func splitCachePVs(pvs []string) (fast []string, slow []string) {
+	for _, pv := range pvs {
+		if isNVMeDisk(pv) {
+			fast = append(fast, pv)
+			continue
+		}
+		slow = append(slow, pv)
+	}
+	return fast, slow
+}
+
+// attachCache creates a cache on the nvme pvs of the volumegroup and attaches it to the given logical volume.
+// The nvme pvs are shared equally between all cached volumes of the volumegroup.
+// see man lvmcache
+func (f *Filesystem) attachCache(lvmtype, vg, lv string, pvs []string, cached int) error {
+	fast, _ := splitCachePVs(pvs)
+	if len(fast) == 0 {
+		return fmt.Errorf("volumegroup %s has no nvme pvs for the %s", vg, lvmtype)
+	}
+	if cached < 1 {
+		cached = 1
+	}
+	extents := fmt.Sprintf("%d%%PVS", 100/cached-cacheMetadataHeadroom)
+	cache := lv + "_cache"
+
+	args := []string{"lvcreate", "--verbose", "--name", cache, "--extents", extents}
+	convert := []string{"lvconvert", "--yes"}
+	switch lvmtype {
+	case "cache":
+		args = append(args, "--type", "cache-pool")
+		convert = append(convert, "--type", "cache", "--cachepool", cache)
+	case "writecache":
+		convert = append(convert, "--type", "writecache", "--cachevol", cache)
+	default:
+		return fmt.Errorf("unsupported cache type:%s", lvmtype)
+	}
+	args = append(args, vg)
+	args = append(args, fast...)
+	convert = append(convert, vg+"/"+lv)
+
+	f.track(kindLogicalVolume, vg+"/"+cache)
+	f.log.Info("lvcreate cache", "args", args)
+	err := executeCommand(command.LVM, args...)
+	if err != nil {
+		f.log.Error("lvcreate cache", "error", err)
+		return err
+	}
+
+	f.log.Info("lvconvert", "args", convert)
+	err = executeCommand(command.LVM, convert...)
+	if err != nil {
+		f.log.Error("lvconvert", "error", err)
+		return err
+	}
+	return nil
+}
+
+// validateLogicalVolume checks the lvmtype of the logical volume against the pvs of its volumegroup
+func validateLogicalVolume(lv *models.V1LogicalVolume, pvs []string, lvs []*models.V1LogicalVolume) []error {
+	var errs []error
+	lvmtype := lvmType(lv)
+	minimum, ok := lvmMinimumPVs[lvmtype]
+	if !ok {
+		return []error{fmt.Errorf("logical volume %s has unsupported lvmtype %q", *lv.Name, lvmtype)}
+	}
+	if len(pvs) < minimum {
+		errs = append(errs, fmt.Errorf("logical volume %s with lvmtype %s requires at least %d pvs in volumegroup %s, got %d", *lv.Name, lvmtype, minimum, *lv.Volumegroup, len(pvs)))
+	}
+
+	switch lvmtype {
+	case "raid10":
+		if len(pvs)%2 != 0 {
+			errs = append(errs, fmt.Errorf("logical volume %s with lvmtype raid10 requires an even number of pvs, got %d", *lv.Name, len(pvs)))
+		}
+	case "thin":
+		if _, err := thinPool(*lv.Volumegroup, lvs); err != nil {
+			errs = append(errs, fmt.Errorf("logical volume %s: %w", *lv.Name, err))
+		}
+		if lv.Size == nil || *lv.Size <= 0 {
+			errs = append(errs, fmt.Errorf("thin volume %s requires a size", *lv.Name))
+		}
+	case "cache", "writecache":
+		fast, slow := splitCachePVs(pvs)
+		if len(fast) == 0 || len(slow) == 0 {
+			errs = append(errs, fmt.Errorf("logical volume %s with lvmtype %s requires nvme and non nvme pvs in volumegroup %s, got %d nvme and %d other", *lv.Name, lvmtype, *lv.Volumegroup, len(fast), len(slow)))
+		}
+	}
+	return errs
+}
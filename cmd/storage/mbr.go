@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/metal-stack/metal-go/api/models"
+	"github.com/metal-stack/metal-hammer/pkg/os/command"
+)
+
+const (
+	// biosBootTypeCode is the gpt type of the partition where grub for i386-pc stores its core image
+	biosBootTypeCode = "ef02"
+	// biosBootPartitionNumber is the last gpt partition entry, it does not collide with partitions of the layout
+	biosBootPartitionNumber = 128
+	// mbrMaxPartitions is the number of primary partitions, extended partitions are not supported
+	mbrMaxPartitions = 4
+)
+
+// partitionTableType returns dos if all partitions of the disk have a two digit mbr type like 83, otherwise gpt.
+func partitionTableType(disk *models.V1Disk) string {
+	if len(disk.Partitions) == 0 {
+		return "gpt"
+	}
+	for _, p := range disk.Partitions {
+		if p.Gpttype == nil || !isMBRType(*p.Gpttype) {
+			return "gpt"
+		}
+	}
+	return "dos"
+}
+
+func isMBRType(t string) bool {
+	return len(t) == 2
+}
+
+// needsBIOSBootPartition returns true if grub for i386-pc requires a bios boot partition on this gpt disk
+func needsBIOSBootPartition(firmware string, disk *models.V1Disk) bool {
+	if firmware != "bios" || len(disk.Partitions) == 0 || partitionTableType(disk) != "gpt" {
+		return false
+	}
+	for _, p := range disk.Partitions {
+		if p.Gpttype != nil && strings.EqualFold(*p.Gpttype, biosBootTypeCode) {
+			return false
+		}
+	}
+	return true
+}
+
+// biosBootPartitionArgs returns the sgdisk arguments to create the bios boot partition in the gap
+// between the gpt and the first partition which starts at sector 2048. Without an alignment of one sector
+// sgdisk rounds the start up to 2048 and the partition does not fit into the gap.
+// The arguments are appended after all other partitions, the alignment therefore only applies to this partition.
+func biosBootPartitionArgs() []string {
+	return []string{
+		"--set-alignment=1",
+		fmt.Sprintf("--new=%d:34:2047", biosBootPartitionNumber),
+		fmt.Sprintf("--typecode=%d:%s", biosBootPartitionNumber, biosBootTypeCode),
+		fmt.Sprintf("--change-name=%d:%s", biosBootPartitionNumber, "BIOS boot partition"),
+	}
+}
+
+// sfdiskScript returns the input for sfdisk to create a mbr partition table,
+// the first partition is marked bootable.
+func sfdiskScript(disk *models.V1Disk) string {
+	var sb strings.Builder
+	sb.WriteString("label: dos\n")
+	for i, p := range disk.Partitions {
+		fields := []string{fmt.Sprintf("%s : ", partitionDevice(*disk.Device, *p.Number))}
+		if p.Size != nil && *p.Size > 0 {
+			fields = append(fields, fmt.Sprintf("size=%dMiB,", *p.Size))
+		}
+		fields = append(fields, "type="+*p.Gpttype)
+		if i == 0 {
+			fields = append(fields, ", bootable")
+		}
+		sb.WriteString(strings.Join(fields, "") + "\n")
+	}
+	return sb.String()
+}
+
+// createMBRPartitions creates a dos partition table with sfdisk
+func (f *Filesystem) createMBRPartitions(disk *models.V1Disk) error {
+	script := sfdiskScript(disk)
+	f.log.Info("sfdisk create partitions", "disk", *disk.Device, "script", script)
+
+	path, err := exec.LookPath(command.SFDisk)
+	if err != nil {
+		return fmt.Errorf("unable to locate program:%s in path %w", command.SFDisk, err)
+	}
+	cmd := exec.Command(path, "--wipe", "always", *disk.Device) // nolint:gosec
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		f.log.Error("sfdisk creating partitions failed", "output", string(out), "error", err)
+		return fmt.Errorf("unable to create partitions on %s output:%s %w", *disk.Device, out, err)
+	}
+	return nil
+}
+
+// validateMBRDisk checks that a disk with mbr partition types can be created as dos partition table
+func validateMBRDisk(disk *models.V1Disk) []error {
+	var (
+		errs []error
+		mbr  int
+	)
+	for _, p := range disk.Partitions {
+		if p.Gpttype != nil && isMBRType(*p.Gpttype) {
+			mbr++
+		}
+	}
+	if mbr == 0 {
+		return nil
+	}
+	if mbr != len(disk.Partitions) {
+		return append(errs, fmt.Errorf("disk %s mixes mbr and gpt partition types", *disk.Device))
+	}
+	if len(disk.Partitions) > mbrMaxPartitions {
+		errs = append(errs, fmt.Errorf("disk %s has %d mbr partitions, only %d are supported", *disk.Device, len(disk.Partitions), mbrMaxPartitions))
+	}
+	for _, p := range disk.Partitions {
+		if p.Number != nil && (*p.Number < 1 || *p.Number > mbrMaxPartitions) {
+			errs = append(errs, fmt.Errorf("mbr partition %d on disk %s must be numbered from 1 to %d", *p.Number, *disk.Device, mbrMaxPartitions))
+		}
+	}
+	return errs
+}
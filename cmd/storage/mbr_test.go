@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/metal-stack/metal-go/api/models"
+)
+
+func TestPartitionTable(t *testing.T) {
+	mbr := &models.V1Disk{
+		Device: strPtr("/dev/sda"),
+		Partitions: []*models.V1DiskPartition{
+			{Number: int64Ptr(1), Size: int64Ptr(500), Gpttype: strPtr("83"), Label: "boot"},
+			{Number: int64Ptr(2), Size: int64Ptr(0), Gpttype: strPtr("8e"), Label: "lvm"},
+		},
+	}
+	gpt := &models.V1Disk{
+		Device: strPtr("/dev/nvme0n1"),
+		Partitions: []*models.V1DiskPartition{
+			{Number: int64Ptr(1), Size: int64Ptr(500), Gpttype: strPtr("ef00"), Label: "efi"},
+			{Number: int64Ptr(2), Size: int64Ptr(0), Gpttype: strPtr("8300"), Label: "root"},
+		},
+	}
+
+	if got := partitionTableType(mbr); got != "dos" {
+		t.Errorf("partitionTableType() = %s, want dos", got)
+	}
+	if got := partitionTableType(gpt); got != "gpt" {
+		t.Errorf("partitionTableType() = %s, want gpt", got)
+	}
+
+	want := "label: dos\n/dev/sda1 : size=500MiB,type=83, bootable\n/dev/sda2 : type=8e\n"
+	if got := sfdiskScript(mbr); got != want {
+		t.Errorf("sfdiskScript() = %q, want %q", got, want)
+	}
+
+	if needsBIOSBootPartition("efi", gpt) {
+		t.Errorf("needsBIOSBootPartition() efi must not add a bios boot partition")
+	}
+	if !needsBIOSBootPartition("bios", gpt) {
+		t.Errorf("needsBIOSBootPartition() bios on gpt requires a bios boot partition")
+	}
+	if needsBIOSBootPartition("bios", mbr) {
+		t.Errorf("needsBIOSBootPartition() mbr must not add a bios boot partition")
+	}
+
+	wantArgs := []string{"--set-alignment=1", "--new=128:34:2047", "--typecode=128:ef02", "--change-name=128:BIOS boot partition"}
+	if got := biosBootPartitionArgs(); !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("biosBootPartitionArgs() = %v, want %v", got, wantArgs)
+	}
+
+	mixed := &models.V1Disk{
+		Device: strPtr("/dev/sdb"),
+		Partitions: []*models.V1DiskPartition{
+			{Number: int64Ptr(1), Gpttype: strPtr("83")},
+			{Number: int64Ptr(5), Gpttype: strPtr("8300")},
+		},
+	}
+	if errs := validateMBRDisk(mixed); len(errs) != 1 {
+		t.Errorf("validateMBRDisk() = %v, want one error", errs)
+	}
+	if errs := validateMBRDisk(mbr); len(errs) != 0 {
+		t.Errorf("validateMBRDisk() = %v, want no error", errs)
+	}
+}
@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	gos "os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal-stack/metal-hammer/pkg/os/command"
+	"github.com/metal-stack/v"
+)
+
+var (
+	mdstat = "/proc/mdstat"
+
+	// md1 : active raid1 sdb2[1] sda2[0]
+	// md0 : active (auto-read-only) raid1 sdb1[1] sda1[0]
+	// md127 : inactive sdb[1](S) sda[0](S)
+	mdstatArrayRegex = regexp.MustCompile(`^(md\S+)\s+:\s+(\S+)(.*)$`)
+	// sdb2[1] or sdb[1](S), a member device of an array
+	mdstatMemberRegex = regexp.MustCompile(`^\S+\[\d+\]`)
+	// [=>...................]  recovery =  8.5% (89088/1046528) finish=0.1min speed=89088K/sec
+	mdstatProgressRegex = regexp.MustCompile(`(resync|recovery|reshape|check|repair)\s*=\s*([0-9.]+)%.*finish=(\S+)\s+speed=(\S+)`)
+	// resync=DELAYED
+	mdstatPendingRegex = regexp.MustCompile(`(resync|recovery|reshape|check|repair)\s*=\s*(DELAYED|PENDING)`)
+)
+
+const (
+	// raid sync speeds in KiB/sec, the defaults of the kernel are far too low for fast disks
+	raidSpeedLimitMin = "200000000"
+	raidSpeedLimitMax = "2000000000"
+	// raidStripeCacheSize in pages per device, speeds up the initial sync of raid 4,5 and 6
+	raidStripeCacheSize = "8192"
+)
+
+// MDArray is the sync state of a md array as reported by /proc/mdstat
+type MDArray struct {
+	Name  string
+	State string
+	Level string
+	// Action is the running sync action like resync or recovery, empty if the array is in sync
+	Action string
+	// Percent of the running sync action
+	Percent float64
+	// Finish is the estimated time until the sync is finished
+	Finish string
+	// Speed of the sync
+	Speed string
+}
+
+// InSync returns true if no sync action is running or pending
+func (a MDArray) InSync() bool {
+	return a.Action == ""
+}
+
+func (a MDArray) String() string {
+	if a.InSync() {
+		return fmt.Sprintf("%s %s in sync", a.Name, a.Level)
+	}
+	if a.Finish == "" {
+		return fmt.Sprintf("%s %s %s pending", a.Name, a.Level, a.Action)
+	}
+	return fmt.Sprintf("%s %s %s %.1f%% finish=%s speed=%s", a.Name, a.Level, a.Action, a.Percent, a.Finish, a.Speed)
+}
+
+// ReadMDStat returns the state of all md arrays
+func ReadMDStat() ([]MDArray, error) {
+	content, err := gos.ReadFile(mdstat)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s %w", mdstat, err)
+	}
+	return parseMDStat(string(content)), nil
+}
+
+func parseMDStat(content string) []MDArray {
+	var (
+		arrays  []MDArray
+		current *MDArray
+	)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := mdstatArrayRegex.FindStringSubmatch(line); m != nil {
+			if current != nil {
+				arrays = append(arrays, *current)
+			}
+			current = &MDArray{Name: m[1], State: m[2], Level: mdstatLevel(m[3])}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if m := mdstatProgressRegex.FindStringSubmatch(line); m != nil {
+			current.Action = m[1]
+			current.Percent, _ = strconv.ParseFloat(m[2], 64)
+			current.Finish = m[3]
+			current.Speed = m[4]
+			continue
+		}
+		if m := mdstatPendingRegex.FindStringSubmatch(line); m != nil {
+			current.Action = m[1]
+		}
+	}
+	if current != nil {
+		arrays = append(arrays, *current)
+	}
+	return arrays
+}
+
+// mdstatLevel returns the raid level which follows the state, parenthesised states like (auto-read-only)
+// are skipped, inactive arrays have no level and list their members directly
+func mdstatLevel(rest string) string {
+	for _, field := range strings.Fields(rest) {
+		if strings.HasPrefix(field, "(") {
+			continue
+		}
+		if mdstatMemberRegex.MatchString(field) {
+			return ""
+		}
+		return field
+	}
+	return ""
+}
+
+// ArraysNotInSync returns all md arrays with a running or pending sync action
+func ArraysNotInSync() ([]MDArray, error) {
+	arrays, err := ReadMDStat()
+	if err != nil {
+		return nil, err
+	}
+	var syncing []MDArray
+	for _, a := range arrays {
+		if !a.InSync() {
+			syncing = append(syncing, a)
+		}
+	}
+	return syncing, nil
+}
+
+// WaitForRaidSync polls /proc/mdstat until all arrays are in sync or the timeout is reached.
+// The progress callback is called with all arrays which are not in sync yet.
+func WaitForRaidSync(log *slog.Logger, interval, timeout time.Duration, progress func([]MDArray)) error {
+	start := time.Now()
+	for {
+		syncing, err := ArraysNotInSync()
+		if err != nil {
+			return err
+		}
+		if len(syncing) == 0 {
+			log.Info("all raid arrays are in sync", "took", time.Since(start))
+			return nil
+		}
+		progress(syncing)
+		if time.Since(start) > timeout {
+			return fmt.Errorf("raid arrays not in sync after %s", timeout)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// tuneRaidSync raises the sync speed limits and the stripe cache of the given array
+func (f *Filesystem) tuneRaidSync(array, level string) {
+	// nolint:gosec
+	for file, value := range map[string]string{
+		"/proc/sys/dev/raid/speed_limit_min": raidSpeedLimitMin,
+		"/proc/sys/dev/raid/speed_limit_max": raidSpeedLimitMax,
+	} {
+		err := gos.WriteFile(file, []byte(value), 0644)
+		if err != nil {
+			f.log.Error("unable to set sync speed, ignoring...", "file", file, "error", err)
+		}
+	}
+
+	switch level {
+	case "4", "5", "6":
+	default:
+		return
+	}
+	device := array
+	if resolved, err := filepath.EvalSymlinks(array); err == nil {
+		device = resolved
+	}
+	stripeCache := filepath.Join("/sys/block", filepath.Base(device), "md", "stripe_cache_size")
+	err := gos.WriteFile(stripeCache, []byte(raidStripeCacheSize), 0644) // nolint:gosec
+	if err != nil {
+		f.log.Error("unable to set stripe cache size, ignoring...", "file", stripeCache, "error", err)
+	}
+}
+
+// CreateMdadmConf writes /etc/mdadm/mdadm.conf with all created arrays inside the chroot,
+// this must be done after the image was extracted and before the installer creates the initrd.
+func (f *Filesystem) CreateMdadmConf() error {
+	if len(f.config.Raid) == 0 {
+		return nil
+	}
+
+	path, err := exec.LookPath(command.MDADM)
+	if err != nil {
+		return fmt.Errorf("unable to locate program:%s in path %w", command.MDADM, err)
+	}
+	out, err := exec.Command(path, "--detail", "--scan").Output()
+	if err != nil {
+		return fmt.Errorf("unable to scan mdadm arrays %w", err)
+	}
+
+	// output of
+	// mdadm --detail --scan:
+	//
+	// ARRAY /dev/md1 metadata=1.2 name=any:1 UUID=2e4d5be8:54f2a7e6:d6a8f9e8:1c8dc5a4
+	// ARRAY /dev/md2 metadata=1.2 name=any:2 UUID=76c9ea53:ab62d8e1:13b5c8d7:3f8c5a0e
+	var arrays []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "ARRAY") {
+			arrays = append(arrays, strings.TrimSpace(line))
+		}
+	}
+
+	header := fmt.Sprintf("# created by metal-hammer: %q\n", v.V)
+	content := header + "HOMEHOST <ignore>\nDEVICE partitions containers\n" + strings.Join(arrays, "\n") + "\n"
+
+	configdir := filepath.Join(f.chroot, "etc", "mdadm")
+	err = gos.MkdirAll(configdir, 0755)
+	if err != nil {
+		return err
+	}
+	f.log.Info("write mdadm.conf", "content", content)
+	//nolint:gosec
+	return gos.WriteFile(filepath.Join(configdir, "mdadm.conf"), []byte(content), 0644)
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMDStat(t *testing.T) {
+	content := `Personalities : [raid1] [raid6] [raid5] [raid4]
+md2 : active raid5 sdd1[3] sdc1[1] sdb1[0]
+      2093056 blocks super 1.2 level 5, 512k chunk, algorithm 2 [3/2] [UU_]
+      [=>...................]  recovery =  8.5% (89088/1046528) finish=0.1min speed=89088K/sec
+
+md3 : active raid6 sde1[3] sdf1[2] sdg1[1] sdh1[0]
+      2093056 blocks super 1.2 level 6, 512k chunk, algorithm 2 [4/4] [UUUU]
+      	resync=DELAYED
+
+md1 : active raid1 sdb2[1] sda2[0]
+      523264 blocks super 1.2 [2/2] [UU]
+
+md0 : active (auto-read-only) raid1 sdb1[1] sda1[0]
+      523264 blocks super 1.2 [2/2] [UU]
+      	resync=PENDING
+
+md127 : inactive sdd[1](S) sdc[0](S)
+      2093056 blocks super 1.2
+
+unused devices: <none>
+`
+	want := []MDArray{
+		{Name: "md2", State: "active", Level: "raid5", Action: "recovery", Percent: 8.5, Finish: "0.1min", Speed: "89088K/sec"},
+		{Name: "md3", State: "active", Level: "raid6", Action: "resync"},
+		{Name: "md1", State: "active", Level: "raid1"},
+		{Name: "md0", State: "active", Level: "raid1", Action: "resync"},
+		{Name: "md127", State: "inactive"},
+	}
+	got := parseMDStat(content)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseMDStat() = %v, want %v", got, want)
+	}
+	if !got[2].InSync() || got[0].InSync() {
+		t.Errorf("InSync() not as expected")
+	}
+	if got[0].String() != "md2 raid5 recovery 8.5% finish=0.1min speed=89088K/sec" {
+		t.Errorf("String() = %s", got[0].String())
+	}
+}
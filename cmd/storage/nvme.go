@@ -0,0 +1,408 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	gos "os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/metal-stack/metal-go/api/models"
+	"github.com/metal-stack/metal-hammer/pkg/os/command"
+)
+
+const (
+	// nvmeAllNamespaces addresses all namespaces of a controller
+	nvmeAllNamespaces = "0xffffffff"
+	// nvmeNamespaceManagement is the bit in oacs which tells that the controller supports namespace management
+	nvmeNamespaceManagement = 1 << 3
+	// nvmePreferredBlockSize is used for new namespaces if the controller supports it, 4K performs better than 512 byte sectors
+	nvmePreferredBlockSize = 4096
+)
+
+var (
+	// nvmeNamespaceRegex matches namespace devices like /dev/nvme0n1
+	nvmeNamespaceRegex = regexp.MustCompile(`^/dev/(nvme[0-9]+)n([0-9]+)$`)
+	// nvmeListNamespaceRegex matches the lines of nvme list-ns like [   0]:0x1
+	nvmeListNamespaceRegex = regexp.MustCompile(`^\[\s*[0-9]+\]:0x([0-9a-fA-F]+)$`)
+	// nvmeCreatedNamespaceRegex matches the output of nvme create-ns like create-ns: Success, created nsid:3
+	nvmeCreatedNamespaceRegex = regexp.MustCompile(`created nsid:\s*([0-9]+)`)
+	// nvmeSysfsNamespaceRegex matches the namespaces in the sysfs directory of a controller like nvme0n1 or nvme0c0n1 with multipath
+	nvmeSysfsNamespaceRegex = regexp.MustCompile(`^(nvme[0-9]+)(?:c[0-9]+)?n([0-9]+)$`)
+	// sysClassNVMe contains a directory per nvme controller
+	sysClassNVMe = "/sys/class/nvme"
+)
+
+// namespacePlan are the namespaces to create on a nvme controller
+type namespacePlan struct {
+	// controller device, e.g. /dev/nvme0
+	controller string
+	// disks of the layout, sorted by namespace id
+	disks []*models.V1Disk
+	// replaced are the existing namespaces of the layout which are deleted and created again
+	replaced []string
+	// kept are the ids of the existing namespaces which are not part of the layout, they are never touched
+	kept []int
+}
+
+// planNamespaces returns the namespaces which must be created for the layout. Namespaces are only created
+// on controllers where the layout references namespaces which do not exist yet, existing namespaces are never touched otherwise.
+// Only the existing namespaces of the layout are replaced, other namespaces of the controller are kept.
+func planNamespaces(config models.V1FilesystemLayoutResponse, exists func(device string) bool, attached func(controller string) []int) ([]namespacePlan, error) {
+	var (
+		controllers []string
+		disks       = map[string][]*models.V1Disk{}
+		missing     = map[string]bool{}
+	)
+	for _, disk := range config.Disks {
+		if disk.Device == nil {
+			continue
+		}
+		m := nvmeNamespaceRegex.FindStringSubmatch(*disk.Device)
+		if m == nil {
+			continue
+		}
+		controller := "/dev/" + m[1]
+		if !slices.Contains(controllers, controller) {
+			controllers = append(controllers, controller)
+		}
+		disks[controller] = append(disks[controller], disk)
+		if !exists(*disk.Device) {
+			missing[controller] = true
+		}
+	}
+
+	var plans []namespacePlan
+	for _, controller := range controllers {
+		if !missing[controller] {
+			continue
+		}
+		if !exists(controller) {
+			return nil, fmt.Errorf("nvme controller %s does not exist", controller)
+		}
+		ds := disks[controller]
+		slices.SortFunc(ds, func(a, b *models.V1Disk) int {
+			return namespaceID(*a.Device) - namespaceID(*b.Device)
+		})
+		p := namespacePlan{controller: controller, disks: ds}
+		ids := attached(controller)
+		for _, id := range ids {
+			i := slices.IndexFunc(ds, func(d *models.V1Disk) bool { return namespaceID(*d.Device) == id })
+			if i < 0 {
+				p.kept = append(p.kept, id)
+				continue
+			}
+			p.replaced = append(p.replaced, *ds[i].Device)
+		}
+		// the controller assigns the lowest free id to a new namespace, the ids of the kept namespaces are not free
+		id := 0
+		for _, d := range ds {
+			id++
+			for slices.Contains(p.kept, id) {
+				id++
+			}
+			if namespaceID(*d.Device) != id {
+				return nil, fmt.Errorf("namespaces of nvme controller %s must be numbered consecutively starting with 1 skipping the existing namespaces %v, got %s", controller, p.kept, *d.Device)
+			}
+		}
+		plans = append(plans, p)
+	}
+	return plans, nil
+}
+
+func namespaceID(device string) int {
+	m := nvmeNamespaceRegex.FindStringSubmatch(device)
+	if m == nil {
+		return 0
+	}
+	id, _ := strconv.Atoi(m[2])
+	return id
+}
+
+// capacity returns the capacity which is available for the namespaces of the plan,
+// this is the unallocated capacity of the controller and the capacity of the replaced namespaces.
+func (p namespacePlan) capacity(unallocated uint64, deviceSize deviceSizeFunc) uint64 {
+	capacity := unallocated
+	for _, device := range p.replaced {
+		if size, ok := deviceSize(device); ok {
+			capacity += size
+		}
+	}
+	return capacity
+}
+
+// sizes returns the size in blocks of every namespace, a namespace with a partition which fills the remaining space gets the remaining capacity.
+func (p namespacePlan) sizes(capacity, blockSize uint64) ([]uint64, error) {
+	var (
+		sizes    = make([]uint64, len(p.disks))
+		fill     = -1
+		required uint64
+	)
+	for i, d := range p.disks {
+		size := gptOverhead
+		for _, part := range d.Partitions {
+			if part.Size == nil {
+				continue
+			}
+			if *part.Size == 0 {
+				if fill >= 0 && fill != i {
+					return nil, fmt.Errorf("only one namespace of nvme controller %s can fill the remaining capacity", p.controller)
+				}
+				fill = i
+				size += mib
+				continue
+			}
+			size += uint64(*part.Size) * mib // nolint:gosec
+		}
+		sizes[i] = (size + blockSize - 1) / blockSize
+		required += sizes[i] * blockSize
+	}
+	if required > capacity {
+		return nil, fmt.Errorf("namespaces of nvme controller %s require %d MiB, but only %d MiB are available", p.controller, required/mib, capacity/mib)
+	}
+	if fill >= 0 {
+		sizes[fill] += (capacity - required) / blockSize
+	}
+	return sizes, nil
+}
+
+// nvmeController is the part of nvme id-ctrl which is required for namespace management
+type nvmeController struct {
+	ControllerID int    `json:"cntlid"`
+	OACS         int    `json:"oacs"`
+	Namespaces   int    `json:"nn"`
+	Capacity     uint64 `json:"tnvmcap"`
+	Unallocated  uint64 `json:"unvmcap"`
+}
+
+// nvmeNamespace is the part of nvme id-ns which is required to select the lba format
+type nvmeNamespace struct {
+	LBAFormats []struct {
+		MetadataSize int `json:"ms"`
+		// DataSize is the block size as power of two
+		DataSize int `json:"ds"`
+	} `json:"lbafs"`
+}
+
+// lbaFormat returns the index of the lba format to use and its block size, 4K without metadata is preferred.
+func (ns nvmeNamespace) lbaFormat() (int, uint64) {
+	format, blockSize := 0, uint64(512)
+	for i, f := range ns.LBAFormats {
+		if f.MetadataSize != 0 {
+			continue
+		}
+		size := uint64(1) << f.DataSize
+		if size == nvmePreferredBlockSize {
+			return i, size
+		}
+		if i == 0 {
+			blockSize = size
+		}
+	}
+	return format, blockSize
+}
+
+func nvmeJSON(result any, args ...string) error {
+	path, err := exec.LookPath(command.NVME)
+	if err != nil {
+		return fmt.Errorf("unable to locate program:%s in path %w", command.NVME, err)
+	}
+	args = append(args, "--output-format=json")
+	out, err := exec.Command(path, args...).Output()
+	if err != nil {
+		return fmt.Errorf("unable to execute %s %v %w", command.NVME, args, err)
+	}
+	return json.Unmarshal(out, result)
+}
+
+// nvmeCapacity returns the controller capacities and the block size which will be used for new namespaces,
+// id-ctrl and id-ns are admin commands which only read the identify data and do not modify the controller.
+func nvmeCapacity(controller string) (nvmeController, int, uint64, error) {
+	var ctrl nvmeController
+	err := nvmeJSON(&ctrl, "id-ctrl", controller)
+	if err != nil {
+		return ctrl, 0, 0, err
+	}
+	if ctrl.OACS&nvmeNamespaceManagement == 0 {
+		return ctrl, 0, 0, fmt.Errorf("nvme controller %s does not support namespace management", controller)
+	}
+	// the common namespace capabilities describe the lba formats available for new namespaces
+	var ns nvmeNamespace
+	err = nvmeJSON(&ns, "id-ns", controller, "--namespace-id="+nvmeAllNamespaces)
+	if err != nil {
+		return ctrl, 0, 0, err
+	}
+	format, blockSize := ns.lbaFormat()
+	return ctrl, format, blockSize, nil
+}
+
+// namespaceDeviceSize returns the size of the namespaces which will be created for the validation of the layout
+func namespaceDeviceSize(plans []namespacePlan, deviceSize deviceSizeFunc) (deviceSizeFunc, error) {
+	planned := map[string]uint64{}
+	for _, p := range plans {
+		ctrl, _, blockSize, err := nvmeCapacity(p.controller)
+		if err != nil {
+			return nil, err
+		}
+		sizes, err := p.sizes(p.capacity(ctrl.Unallocated, deviceSize), blockSize)
+		if err != nil {
+			return nil, err
+		}
+		for i, d := range p.disks {
+			planned[*d.Device] = sizes[i] * blockSize
+		}
+	}
+	return func(device string) (uint64, bool) {
+		if size, ok := planned[device]; ok {
+			return size, true
+		}
+		return deviceSize(device)
+	}, nil
+}
+
+// createNamespaces deletes the replaced namespaces of the planned controllers and creates the namespaces of the layout
+func (f *Filesystem) createNamespaces() error {
+	plans, err := planNamespaces(f.config, deviceExists, allocatedNamespaces)
+	if err != nil {
+		return err
+	}
+	for _, p := range plans {
+		err := f.createControllerNamespaces(p)
+		if err != nil {
+			return fmt.Errorf("unable to create namespaces on %s %w", p.controller, err)
+		}
+	}
+	return nil
+}
+
+func (f *Filesystem) createControllerNamespaces(p namespacePlan) error {
+	ctrl, format, blockSize, err := nvmeCapacity(p.controller)
+	if err != nil {
+		return err
+	}
+	sizes, err := p.sizes(p.capacity(ctrl.Unallocated, sysfsDeviceSize), blockSize)
+	if err != nil {
+		return err
+	}
+	if len(sizes)+len(p.kept) > ctrl.Namespaces {
+		return fmt.Errorf("controller supports only %d namespaces, %d requested and %d kept", ctrl.Namespaces, len(sizes), len(p.kept))
+	}
+
+	for _, device := range p.replaced {
+		nsid := strconv.Itoa(namespaceID(device))
+		f.log.Info("delete nvme namespace", "controller", p.controller, "namespace", nsid)
+		err = executeCommand(command.NVME, "delete-ns", p.controller, "--namespace-id="+nsid)
+		if err != nil {
+			return fmt.Errorf("unable to delete namespace %s %w", nsid, err)
+		}
+	}
+
+	var devices []string
+	for i, d := range p.disks {
+		planned := namespaceID(*d.Device)
+		blocks := strconv.FormatUint(sizes[i], 10)
+		f.log.Info("create nvme namespace", "controller", p.controller, "namespace", planned, "blocks", blocks, "blocksize", blockSize)
+		out, err := executeCommandOutput(command.NVME, "create-ns", p.controller, "--nsze="+blocks, "--ncap="+blocks, "--flbas="+strconv.Itoa(format))
+		if err != nil {
+			return fmt.Errorf("unable to create namespace %d %w", planned, err)
+		}
+		// the controller assigns the id, it is tracked and attached as returned even if it differs from the planned id
+		created, err := createdNamespaceID(string(out))
+		if err != nil {
+			return err
+		}
+		f.track(kindNamespace, fmt.Sprintf("%sn%d", p.controller, created), p.controller)
+		if created != planned {
+			return fmt.Errorf("controller created namespace %d instead of %d for %s", created, planned, *d.Device)
+		}
+		nsid := strconv.Itoa(created)
+		err = executeCommand(command.NVME, "attach-ns", p.controller, "--namespace-id="+nsid, "--controllers="+strconv.Itoa(ctrl.ControllerID))
+		if err != nil {
+			return fmt.Errorf("unable to attach namespace %s %w", nsid, err)
+		}
+		devices = append(devices, *d.Device)
+	}
+
+	err = executeCommand(command.NVME, "ns-rescan", p.controller)
+	if err != nil {
+		return fmt.Errorf("unable to rescan namespaces %w", err)
+	}
+	return waitForDevices(f.log, settleTimeout, devices...)
+}
+
+// deviceExists returns true if the block device or the nvme controller is present
+func deviceExists(device string) bool {
+	if _, ok := sysfsDeviceSize(device); ok {
+		return true
+	}
+	_, err := gos.Stat(filepath.Join(sysClassNVMe, filepath.Base(device)))
+	return err == nil
+}
+
+// createdNamespaceID returns the id of the namespace which was created by nvme create-ns
+func createdNamespaceID(output string) (int, error) {
+	m := nvmeCreatedNamespaceRegex.FindStringSubmatch(output)
+	if m == nil {
+		return 0, fmt.Errorf("unable to find the created namespace id in %q", strings.TrimSpace(output))
+	}
+	return strconv.Atoi(m[1])
+}
+
+// allocatedNamespaces returns the sorted ids of all namespaces of the controller from nvme list-ns --all.
+// Detached namespaces are unknown to the kernel but occupy their id as well. If the controller can not be asked
+// only the attached namespaces are returned.
+func allocatedNamespaces(controller string) []int {
+	out, err := reportOutput(command.NVME, "list-ns", controller, "--all")
+	if err != nil {
+		return attachedNamespaces(controller)
+	}
+	return parseNamespaceList(string(out))
+}
+
+// parseNamespaceList parses the output of nvme list-ns:
+//
+//	[   0]:0x1
+//	[   1]:0x3
+func parseNamespaceList(output string) []int {
+	var ids []int
+	for _, line := range strings.Split(output, "\n") {
+		m := nvmeListNamespaceRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		id, err := strconv.ParseInt(m[1], 16, 32)
+		if err != nil || slices.Contains(ids, int(id)) {
+			continue
+		}
+		ids = append(ids, int(id))
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// attachedNamespaces returns the sorted ids of the namespaces of the controller which are known to the kernel
+func attachedNamespaces(controller string) []int {
+	name := filepath.Base(controller)
+	entries, err := gos.ReadDir(filepath.Join(sysClassNVMe, name))
+	if err != nil {
+		return nil
+	}
+	var ids []int
+	for _, e := range entries {
+		m := nvmeSysfsNamespaceRegex.FindStringSubmatch(e.Name())
+		if m == nil || m[1] != name {
+			continue
+		}
+		id, err := strconv.Atoi(m[2])
+		if err != nil || slices.Contains(ids, id) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/metal-stack/metal-go/api/models"
+)
+
+func TestPlanNamespaces(t *testing.T) {
+	existing := map[string]bool{"/dev/nvme0": true, "/dev/nvme0n1": true, "/dev/nvme1": true, "/dev/nvme1n1": true, "/dev/sda": true}
+	exists := func(device string) bool { return existing[device] }
+	namespaces := map[string][]int{"/dev/nvme0": {1}, "/dev/nvme1": {1}}
+	attached := func(controller string) []int { return namespaces[controller] }
+
+	disk := func(device string, sizes ...int64) *models.V1Disk {
+		d := &models.V1Disk{Device: strPtr(device)}
+		for i, s := range sizes {
+			d.Partitions = append(d.Partitions, &models.V1DiskPartition{Number: int64Ptr(int64(i + 1)), Size: int64Ptr(s)})
+		}
+		return d
+	}
+
+	config := models.V1FilesystemLayoutResponse{
+		Disks: []*models.V1Disk{
+			disk("/dev/sda", 100),
+			disk("/dev/nvme0n2", 0),
+			disk("/dev/nvme0n1", 500, 10000),
+			disk("/dev/nvme1n1", 0),
+		},
+	}
+	plans, err := planNamespaces(config, exists, attached)
+	if err != nil {
+		t.Fatalf("planNamespaces() error = %v", err)
+	}
+	if len(plans) != 1 || plans[0].controller != "/dev/nvme0" {
+		t.Fatalf("planNamespaces() = %v, want plan for /dev/nvme0 only", plans)
+	}
+	if *plans[0].disks[0].Device != "/dev/nvme0n1" || *plans[0].disks[1].Device != "/dev/nvme0n2" {
+		t.Errorf("planNamespaces() disks not sorted by namespace id")
+	}
+	if !reflect.DeepEqual(plans[0].replaced, []string{"/dev/nvme0n1"}) || len(plans[0].kept) != 0 {
+		t.Errorf("planNamespaces() replaced = %v kept = %v, want /dev/nvme0n1 replaced and nothing kept", plans[0].replaced, plans[0].kept)
+	}
+
+	// the replaced namespace is deleted before, its capacity is available for the new namespaces
+	deviceSize := func(device string) (uint64, bool) {
+		if device == "/dev/nvme0n1" {
+			return 60 * 1024 * mib, true
+		}
+		return 0, false
+	}
+	if got := plans[0].capacity(40*1024*mib, deviceSize); got != 100*1024*mib {
+		t.Errorf("capacity() = %d, want %d", got, 100*1024*mib)
+	}
+
+	capacity := 100 * 1024 * mib
+	sizes, err := plans[0].sizes(capacity, 4096)
+	if err != nil {
+		t.Fatalf("sizes() error = %v", err)
+	}
+	first := (10500*mib + gptOverhead) / 4096
+	want := []uint64{first, capacity/4096 - first}
+	if !reflect.DeepEqual(sizes, want) {
+		t.Errorf("sizes() = %v, want %v", sizes, want)
+	}
+
+	_, err = plans[0].sizes(10*1024*mib, 4096)
+	if err == nil {
+		t.Errorf("sizes() expected error if capacity is too small")
+	}
+
+	// namespaces which are not part of the layout are kept, new namespaces get the ids in between
+	namespaces["/dev/nvme0"] = []int{1, 2}
+	config.Disks = []*models.V1Disk{disk("/dev/nvme0n1", 0), disk("/dev/nvme0n3", 100)}
+	plans, err = planNamespaces(config, exists, attached)
+	if err != nil {
+		t.Fatalf("planNamespaces() error = %v", err)
+	}
+	if !reflect.DeepEqual(plans[0].replaced, []string{"/dev/nvme0n1"}) || !reflect.DeepEqual(plans[0].kept, []int{2}) {
+		t.Errorf("planNamespaces() replaced = %v kept = %v, want /dev/nvme0n1 replaced and 2 kept", plans[0].replaced, plans[0].kept)
+	}
+
+	config.Disks = append(config.Disks, disk("/dev/nvme0n5", 100))
+	_, err = planNamespaces(config, exists, attached)
+	if err == nil {
+		t.Errorf("planNamespaces() expected error for non consecutive namespaces")
+	}
+}
+
+func TestAttachedNamespaces(t *testing.T) {
+	original := sysClassNVMe
+	defer func() { sysClassNVMe = original }()
+	sysClassNVMe = t.TempDir()
+	for _, dir := range []string{"nvme0/nvme0n1", "nvme0/nvme0c0n3", "nvme0/nvme0n3", "nvme0/device", "nvme0/nvme1n2", "nvme1/nvme1n1"} {
+		if err := os.MkdirAll(filepath.Join(sysClassNVMe, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := attachedNamespaces("/dev/nvme0"); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Errorf("attachedNamespaces() = %v, want [1 3]", got)
+	}
+	if got := attachedNamespaces("/dev/nvme2"); got != nil {
+		t.Errorf("attachedNamespaces() = %v, want none", got)
+	}
+}
+
+func TestLBAFormat(t *testing.T) {
+	var ns nvmeNamespace
+	err := json.Unmarshal([]byte(`{"lbafs":[{"ms":0,"ds":9,"rp":2},{"ms":8,"ds":12,"rp":1},{"ms":0,"ds":12,"rp":0}]}`), &ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, blockSize := ns.lbaFormat()
+	if format != 2 || blockSize != 4096 {
+		t.Errorf("lbaFormat() = %d %d, want 2 4096", format, blockSize)
+	}
+}
+
+func TestParseSanitizeLog(t *testing.T) {
+	tests := []struct {
+		name         string
+		log          string
+		wantProgress float64
+		wantStatus   int
+	}{
+		{
+			name:         "nvme-cli 1.x",
+			log:          `{"sprog":32768,"sstat":258,"cdw10_info":4}`,
+			wantProgress: 50,
+			wantStatus:   nvmeSanitizeStatusInProgress,
+		},
+		{
+			name:         "nvme-cli 2.x",
+			log:          `{"nvme0":{"sprog":65535,"sstat":{"global_erased":1,"no_cmplted_passes":1,"status":1},"cdw10_info":4}}`,
+			wantProgress: 65535 * 100.0 / 65536,
+			wantStatus:   nvmeSanitizeStatusCompleted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			progress, status, err := parseSanitizeLog([]byte(tt.log))
+			if err != nil {
+				t.Fatalf("parseSanitizeLog() error = %v", err)
+			}
+			if progress != tt.wantProgress || status != tt.wantStatus {
+				t.Errorf("parseSanitizeLog() = %v %d, want %v %d", progress, status, tt.wantProgress, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNVMeControllerMethods(t *testing.T) {
+	d := NewDisks(slog.Default())
+	tests := []struct {
+		name       string
+		caps       nvmeCapabilities
+		want       []string
+		wantCrypto []bool
+	}{
+		{
+			name:       "all erases supported",
+			caps:       nvmeCapabilities{Sanicap: nvmeSanitizeCrypto | nvmeSanitizeBlock, FNA: nvmeFormatCrypto},
+			want:       []string{"nvme-sanitize-crypto", "nvme-sanitize-block", "nvme-format-crypto", "nvme-format"},
+			wantCrypto: []bool{true, false, true, false},
+		},
+		{
+			name:       "format only",
+			want:       []string{"nvme-format"},
+			wantCrypto: []bool{false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				names  []string
+				crypto []bool
+			)
+			for _, m := range d.nvmeControllerMethods("/dev/nvme0", tt.caps, 1024) {
+				names = append(names, m.name)
+				crypto = append(crypto, m.crypto)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("nvmeControllerMethods() = %v, want %v", names, tt.want)
+			}
+			if !reflect.DeepEqual(crypto, tt.wantCrypto) {
+				t.Errorf("nvmeControllerMethods() crypto = %v, want %v", crypto, tt.wantCrypto)
+			}
+		})
+	}
+}
+
+func TestParseNamespaceList(t *testing.T) {
+	// namespace 2 is detached, it is only reported by list-ns --all
+	output := "[   0]:0x1\n[   1]:0x2\n[   2]:0xa\n"
+	if got := parseNamespaceList(output); !reflect.DeepEqual(got, []int{1, 2, 10}) {
+		t.Errorf("parseNamespaceList() = %v, want [1 2 10]", got)
+	}
+	if got := parseNamespaceList(""); got != nil {
+		t.Errorf("parseNamespaceList() = %v, want none", got)
+	}
+}
+
+func TestCreatedNamespaceID(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    int
+		wantErr bool
+	}{
+		{
+			name:   "created",
+			output: "create-ns: Success, created nsid:3\n",
+			want:   3,
+		},
+		{
+			name:    "no id",
+			output:  "NVMe status: Namespace Insufficient Capacity\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := createdNamespaceID(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("createdNamespaceID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("createdNamespaceID() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
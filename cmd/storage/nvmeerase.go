@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jaypipes/ghw"
+	"github.com/metal-stack/metal-hammer/pkg/os"
+	"github.com/metal-stack/metal-hammer/pkg/os/command"
+)
+
+const (
+	// sanicap bits of id-ctrl
+	nvmeSanitizeCrypto = 1 << 0
+	nvmeSanitizeBlock  = 1 << 1
+	// fna bits of id-ctrl, a format or a secure erase of one namespace may apply to all namespaces of the controller
+	nvmeFormatAllNamespaces = 1 << 0
+	nvmeEraseAllNamespaces  = 1 << 1
+	// fna bit of id-ctrl which tells that a format with crypto erase is supported
+	nvmeFormatCrypto = 1 << 2
+
+	// sanitize actions
+	nvmeSanitizeActionBlock  = "2"
+	nvmeSanitizeActionCrypto = "4"
+
+	// sanitize status of the sanitize log
+	nvmeSanitizeStatusNever      = 0
+	nvmeSanitizeStatusCompleted  = 1
+	nvmeSanitizeStatusInProgress = 2
+	nvmeSanitizeStatusFailed     = 3
+	nvmeSanitizeStatusNoDealloc  = 4
+
+	nvmeSanitizePollInterval = 10 * time.Second
+	nvmeSanitizeTimeout      = 6 * time.Hour
+)
+
+// nvmeCapabilities are the erase capabilities of a nvme controller from id-ctrl
+type nvmeCapabilities struct {
+	Sanicap int `json:"sanicap"`
+	FNA     int `json:"fna"`
+}
+
+// controllerErase executes an erase of a controller only once, all namespaces of the controller share the result
+type controllerErase struct {
+	once sync.Once
+	err  error
+}
+
+// nvmeMethods returns the erase methods supported by the controller of the namespace, the most thorough first.
+// Sanitize and format operate on all namespaces of the controller, they are not used if another namespace of the controller is protected.
+func (d *Disks) nvmeMethods(device string, bytes uint64) []wipeMethod {
+	controller := nvmeControllerOf(device)
+
+	var caps nvmeCapabilities
+	err := nvmeJSON(&caps, "id-ctrl", controller)
+	if err != nil {
+		d.log.Warn("wipe", "disk", device, "message", "unable to read nvme capabilities, fall back to format", "error", err)
+	}
+	d.log.Info("wipe", "disk", device, "controller", controller, "sanicap", caps.Sanicap, "fna", caps.FNA)
+
+	if d.protectedControllers[controller] {
+		d.log.Warn("wipe", "disk", device, "controller", controller, "message", "controller has a protected namespace, only erase this namespace")
+		return d.nvmeNamespaceMethods(device, caps, err == nil, bytes)
+	}
+
+	return d.nvmeControllerMethods(controller, caps, bytes)
+}
+
+// nvmeControllerMethods returns the erase methods of the whole controller. The crypto erases are verified by the
+// status of the controller, the sanitize log or the status of the format, because the controller may return random
+// data afterwards. A failed crypto erase falls through to the next method.
+func (d *Disks) nvmeControllerMethods(controller string, caps nvmeCapabilities, bytes uint64) []wipeMethod {
+	var methods []wipeMethod
+	if caps.Sanicap&nvmeSanitizeCrypto != 0 {
+		methods = append(methods, wipeMethod{name: "nvme-sanitize-crypto", crypto: true, erase: func() error {
+			return d.eraseController(controller, "sanitize-crypto", func() error { return d.sanitizeNVMe(controller, nvmeSanitizeActionCrypto, bytes) })
+		}})
+	}
+	if caps.Sanicap&nvmeSanitizeBlock != 0 {
+		methods = append(methods, wipeMethod{name: "nvme-sanitize-block", erase: func() error {
+			return d.eraseController(controller, "sanitize-block", func() error { return d.sanitizeNVMe(controller, nvmeSanitizeActionBlock, bytes) })
+		}})
+	}
+	if caps.FNA&nvmeFormatCrypto != 0 {
+		methods = append(methods, wipeMethod{name: "nvme-format-crypto", crypto: true, erase: func() error {
+			return d.eraseController(controller, "format-crypto", func() error { return d.secureEraseNVMe(controller, "2") })
+		}})
+	}
+	return append(methods, wipeMethod{name: "nvme-format", erase: func() error {
+		return d.eraseController(controller, "format", func() error { return d.secureEraseNVMe(controller, "1") })
+	}})
+}
+
+// nvmeNamespaceMethods returns the erase methods which only affect the given namespace. A format is limited to the namespace
+// only if the controller is known to not apply a format or secure erase to all namespaces, otherwise the namespace is discarded.
+func (d *Disks) nvmeNamespaceMethods(device string, caps nvmeCapabilities, known bool, bytes uint64) []wipeMethod {
+	var methods []wipeMethod
+	if known && caps.FNA&(nvmeFormatAllNamespaces|nvmeEraseAllNamespaces) == 0 {
+		methods = append(methods, wipeMethod{name: "nvme-format-namespace", erase: func() error { return d.formatNVMeNamespace(device) }})
+	}
+	return append(methods, wipeMethod{name: "discard", erase: func() error { return d.discard(device, bytes) }})
+}
+
+// protectedNVMeControllers returns the nvme controllers with at least one protected namespace,
+// a sanitize or format of such a controller would erase the protected namespace as well.
+func protectedNVMeControllers(disks []*ghw.Disk, protected ProtectedDisks) map[string]bool {
+	controllers := map[string]bool{}
+	for _, disk := range disks {
+		device := "/dev/" + disk.Name
+		if !isNVMeDisk(device) {
+			continue
+		}
+		if _, ok := protected.Match(disk); ok {
+			controllers[nvmeControllerOf(device)] = true
+		}
+	}
+	return controllers
+}
+
+// eraseController runs the erase only once per controller and action, namespaces of the same controller are wiped in parallel
+func (d *Disks) eraseController(controller, action string, erase func() error) error {
+	d.mu.Lock()
+	if d.controllerErases == nil {
+		d.controllerErases = map[string]*controllerErase{}
+	}
+	key := controller + "/" + action
+	ce, ok := d.controllerErases[key]
+	if !ok {
+		ce = &controllerErase{}
+		d.controllerErases[key] = ce
+	}
+	d.mu.Unlock()
+
+	ce.once.Do(func() {
+		ce.err = erase()
+	})
+	return ce.err
+}
+
+// nvmeControllerOf returns the controller of a namespace, e.g. /dev/nvme0 for /dev/nvme0n1
+func nvmeControllerOf(device string) string {
+	m := nvmeNamespaceRegex.FindStringSubmatch(device)
+	if m == nil {
+		return device
+	}
+	return "/dev/" + m[1]
+}
+
+// Secure erase is done via:
+// nvme format /dev/nvme0 --namespace-id=0xffffffff --ses=1 --force
+// ses=1 is a user data erase, ses=2 a cryptographic erase.
+// see: https://github.com/linux-nvme/nvme-cli/blob/master/Documentation/nvme-format.txt
+func (d *Disks) secureEraseNVMe(controller, ses string) error {
+	d.log.Info("wipe", "controller", controller, "message", "start format of all namespaces", "ses", ses)
+	err := os.ExecuteCommand(command.NVME, "format", controller, "--namespace-id="+nvmeAllNamespaces, "--ses="+ses, "--force")
+	if err != nil {
+		return fmt.Errorf("unable to format nvme controller %s %w", controller, err)
+	}
+	return nil
+}
+
+// formatNVMeNamespace does a user data erase of a single namespace:
+// nvme format /dev/nvme0n1 --ses=1 --force
+func (d *Disks) formatNVMeNamespace(device string) error {
+	d.log.Info("wipe", "disk", device, "message", "start format of namespace")
+	err := os.ExecuteCommand(command.NVME, "format", device, "--ses=1", "--force")
+	if err != nil {
+		return fmt.Errorf("unable to format nvme namespace %s %w", device, err)
+	}
+	return nil
+}
+
+// sanitizeNVMe starts a sanitize of the whole controller and waits until it is finished,
+// the progress is reported with the size of the namespace which started the sanitize.
+// Only a completed status in the sanitize log is a success, a crypto erase is verified by it.
+func (d *Disks) sanitizeNVMe(controller, action string, bytes uint64) error {
+	d.log.Info("wipe", "controller", controller, "message", "start sanitize", "action", action)
+	err := os.ExecuteCommand(command.NVME, "sanitize", controller, "--sanact="+action)
+	if err != nil {
+		return fmt.Errorf("unable to start sanitize of nvme controller %s %w", controller, err)
+	}
+
+	start := time.Now()
+	reporter := d.newProgressReporter(controller, "nvme-sanitize", bytes)
+	for {
+		time.Sleep(nvmeSanitizePollInterval)
+		progress, status, err := nvmeSanitizeLog(controller)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case nvmeSanitizeStatusCompleted, nvmeSanitizeStatusNoDealloc:
+			d.log.Info("wipe", "controller", controller, "message", "sanitize finished", "took", time.Since(start))
+			return nil
+		case nvmeSanitizeStatusFailed:
+			return fmt.Errorf("sanitize of nvme controller %s failed", controller)
+		case nvmeSanitizeStatusNever:
+			return fmt.Errorf("sanitize of nvme controller %s was not started", controller)
+		}
+		reporter.updatePercent(progress)
+		if time.Since(start) > nvmeSanitizeTimeout {
+			return fmt.Errorf("sanitize of nvme controller %s not finished after %s", controller, nvmeSanitizeTimeout)
+		}
+	}
+}
+
+// nvmeSanitizeLog returns the progress in percent and the status of the running sanitize
+func nvmeSanitizeLog(controller string) (float64, int, error) {
+	path, err := exec.LookPath(command.NVME)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to locate program:%s in path %w", command.NVME, err)
+	}
+	out, err := exec.Command(path, "sanitize-log", controller, "--output-format=json").Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to read sanitize log of %s %w", controller, err)
+	}
+	return parseSanitizeLog(out)
+}
+
+// parseSanitizeLog parses the sanitize log, depending on the version of nvme-cli the log is either
+// the top level object or nested below the name of the device, sstat is either a number or an object with status.
+func parseSanitizeLog(out []byte) (float64, int, error) {
+	var raw map[string]json.RawMessage
+	err := json.Unmarshal(out, &raw)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to parse sanitize log %w", err)
+	}
+	if _, ok := raw["sprog"]; !ok {
+		for _, nested := range raw {
+			var inner map[string]json.RawMessage
+			if json.Unmarshal(nested, &inner) == nil {
+				if _, ok := inner["sprog"]; ok {
+					raw = inner
+					break
+				}
+			}
+		}
+	}
+
+	sprog, err := strconv.ParseFloat(string(raw["sprog"]), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to parse sanitize progress %w", err)
+	}
+
+	var status int
+	sstat := raw["sstat"]
+	if number, err := strconv.Atoi(string(sstat)); err == nil {
+		status = number
+	} else {
+		var s struct {
+			Status int `json:"status"`
+		}
+		err = json.Unmarshal(sstat, &s)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unable to parse sanitize status %w", err)
+		}
+		status = s.Status
+	}
+	// the status is encoded in the lowest 3 bits
+	return sprog * 100 / 65536, status & 0x7, nil
+}
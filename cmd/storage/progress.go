@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaypipes/ghw"
+	"github.com/metal-stack/metal-hammer/pkg/api"
+)
+
+const (
+	// wipeProgressInterval is the minimum time between two progress reports of a disk
+	wipeProgressInterval = time.Minute
+	// DefaultWipeConcurrency is the number of disks of the same controller which are wiped in parallel
+	DefaultWipeConcurrency = 4
+)
+
+// WipeProgress is the progress of the wipe of a single disk
+type WipeProgress struct {
+	Device string
+	Method string
+	// Bytes erased so far
+	Bytes uint64
+	// Total bytes of the disk
+	Total uint64
+	// Rate in bytes per second
+	Rate float64
+	// ETA is the estimated time until the wipe is finished
+	ETA time.Duration
+}
+
+func (p WipeProgress) String() string {
+	percent := 0.0
+	if p.Total > 0 {
+		percent = float64(p.Bytes) * 100 / float64(p.Total)
+	}
+	return fmt.Sprintf("%s %s %.1f%% %s of %s %s/s eta %s", p.Device, p.Method, percent, humanBytes(float64(p.Bytes)), humanBytes(float64(p.Total)), humanBytes(p.Rate), p.ETA.Round(time.Second))
+}
+
+// progressReporter throttles the progress reports of a single disk
+type progressReporter struct {
+	device string
+	method string
+	total  uint64
+	start  time.Time
+	last   time.Time
+	report func(WipeProgress)
+	mu     sync.Mutex
+}
+
+func (d *Disks) newProgressReporter(device, method string, total uint64) *progressReporter {
+	return &progressReporter{
+		device: device,
+		method: method,
+		total:  total,
+		start:  time.Now(),
+		report: d.reportProgress,
+	}
+}
+
+// update reports the progress if the last report is older than wipeProgressInterval
+func (r *progressReporter) update(done uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.last) < wipeProgressInterval {
+		return
+	}
+	r.last = now
+	r.report(progressOf(r.device, r.method, done, r.total, now.Sub(r.start)))
+}
+
+// updatePercent reports the progress of erase methods which only report percent, e.g. sanitize
+func (r *progressReporter) updatePercent(percent float64) {
+	r.update(uint64(percent * float64(r.total) / 100))
+}
+
+func progressOf(device, method string, done, total uint64, elapsed time.Duration) WipeProgress {
+	p := WipeProgress{Device: device, Method: method, Bytes: done, Total: total}
+	if elapsed > 0 {
+		p.Rate = float64(done) / elapsed.Seconds()
+	}
+	if p.Rate > 0 && total > done {
+		p.ETA = time.Duration(float64(total-done) / p.Rate * float64(time.Second))
+	}
+	return p
+}
+
+func (d *Disks) reportProgress(p WipeProgress) {
+	d.log.Info("wipe", "progress", p.String())
+	if d.progress != nil {
+		d.progress(p)
+	}
+}
+
+// WipeThroughput is the throughput of all disks of the same drive type
+type WipeThroughput struct {
+	DriveType string
+	Disks     int
+	// Rate is the average rate in bytes per second
+	Rate float64
+	// Slowest is the device with the lowest rate
+	Slowest     string
+	SlowestRate float64
+}
+
+func (t WipeThroughput) String() string {
+	return fmt.Sprintf("%s: %d disks %s/s, slowest %s %s/s", t.DriveType, t.Disks, humanBytes(t.Rate), t.Slowest, humanBytes(t.SlowestRate))
+}
+
+// WipeSummary returns the throughput per drive type of all successfully wiped disks
+func WipeSummary(certificates []api.WipeCertificate) []WipeThroughput {
+	summary := map[string]*WipeThroughput{}
+	for _, c := range certificates {
+		duration := c.End.Sub(c.Start)
+		if !c.Verified || duration <= 0 {
+			continue
+		}
+		rate := float64(c.Size) / duration.Seconds()
+		t, ok := summary[c.DriveType]
+		if !ok {
+			t = &WipeThroughput{DriveType: c.DriveType, SlowestRate: rate, Slowest: c.Device}
+			summary[c.DriveType] = t
+		}
+		t.Rate = (t.Rate*float64(t.Disks) + rate) / float64(t.Disks+1)
+		t.Disks++
+		if rate < t.SlowestRate {
+			t.Slowest = c.Device
+			t.SlowestRate = rate
+		}
+	}
+	var result []WipeThroughput
+	for _, t := range summary {
+		result = append(result, *t)
+	}
+	slices.SortFunc(result, func(a, b WipeThroughput) int {
+		return strings.Compare(a.DriveType, b.DriveType)
+	})
+	return result
+}
+
+// diskController returns the pci address of the controller of the disk from its bus path,
+// e.g. pci-0000:3b:00.0 for pci-0000:3b:00.0-sas-phy0-lun-0
+func diskController(disk *ghw.Disk) string {
+	fields := strings.SplitN(disk.BusPath, "-", 3)
+	if len(fields) < 2 || fields[0] != "pci" {
+		return "unknown"
+	}
+	return fields[0] + "-" + fields[1]
+}
+
+// humanBytes formats bytes with a decimal unit like dd does
+func humanBytes(b float64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB", "PB"}
+	i := 0
+	for b >= 1000 && i < len(units)-1 {
+		b /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", b, units[i])
+}
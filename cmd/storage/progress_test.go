@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jaypipes/ghw"
+	"github.com/metal-stack/metal-hammer/pkg/api"
+)
+
+func TestProgressOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		done    uint64
+		total   uint64
+		elapsed time.Duration
+		want    WipeProgress
+	}{
+		{
+			name:    "half done",
+			done:    500 * mib,
+			total:   1000 * mib,
+			elapsed: 10 * time.Second,
+			want:    WipeProgress{Device: "/dev/sda", Method: "overwrite", Bytes: 500 * mib, Total: 1000 * mib, Rate: float64(50 * mib), ETA: 10 * time.Second},
+		},
+		{
+			name:  "not started",
+			total: 1000 * mib,
+			want:  WipeProgress{Device: "/dev/sda", Method: "overwrite", Total: 1000 * mib},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := progressOf("/dev/sda", "overwrite", tt.done, tt.total, tt.elapsed)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("progressOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWipeSummary(t *testing.T) {
+	start := time.Now()
+	certificates := []api.WipeCertificate{
+		{Device: "/dev/sda", DriveType: "hdd", Size: 1000, Start: start, End: start.Add(10 * time.Second), Verified: true},
+		{Device: "/dev/sdb", DriveType: "hdd", Size: 1000, Start: start, End: start.Add(40 * time.Second), Verified: true},
+		{Device: "/dev/sdc", DriveType: "hdd", Size: 1000, Start: start, End: start.Add(time.Second), Verified: false},
+		{Device: "/dev/nvme0n1", DriveType: "ssd", Size: 1000, Start: start, End: start.Add(time.Second), Verified: true},
+	}
+	want := []WipeThroughput{
+		{DriveType: "hdd", Disks: 2, Rate: 62.5, Slowest: "/dev/sdb", SlowestRate: 25},
+		{DriveType: "ssd", Disks: 1, Rate: 1000, Slowest: "/dev/nvme0n1", SlowestRate: 1000},
+	}
+	got := WipeSummary(certificates)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WipeSummary() = %v, want %v", got, want)
+	}
+}
+
+func TestDiskController(t *testing.T) {
+	tests := []struct {
+		busPath string
+		want    string
+	}{
+		{busPath: "pci-0000:3b:00.0-sas-phy0-lun-0", want: "pci-0000:3b:00.0"},
+		{busPath: "pci-0000:00:17.0-ata-1", want: "pci-0000:00:17.0"},
+		{busPath: "pci-0000:5e:00.0-nvme-1", want: "pci-0000:5e:00.0"},
+		{busPath: "unknown", want: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.busPath, func(t *testing.T) {
+			if got := diskController(&ghw.Disk{BusPath: tt.busPath}); got != tt.want {
+				t.Errorf("diskController() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jaypipes/ghw"
+)
+
+// protectAttributes are the disk attributes which can be used in protect rules
+var protectAttributes = []string{"serial", "wwn", "model", "transport", "name"}
+
+// ProtectRule matches a disk attribute against a shell pattern like Cruzer*
+type ProtectRule struct {
+	Attribute string
+	Pattern   string
+}
+
+func (r ProtectRule) String() string {
+	return r.Attribute + ":" + r.Pattern
+}
+
+// ProtectedDisks are rules for disks which are neither wiped nor registered, e.g. a usb boot stick or a san lun
+type ProtectedDisks []ProtectRule
+
+// ParseProtectedDisks parses a comma separated list of rules in the form attribute:pattern,
+// e.g. transport:usb,serial:S3Z9NB0K*
+func ParseProtectedDisks(rules string) (ProtectedDisks, error) {
+	var protected ProtectedDisks
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		attribute, pattern, ok := strings.Cut(rule, ":")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("invalid protect rule %q, must be attribute:pattern", rule)
+		}
+		attribute = strings.ToLower(attribute)
+		found := false
+		for _, a := range protectAttributes {
+			if a == attribute {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid protect rule %q, attribute must be one of %v", rule, protectAttributes)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid protect rule %q %w", rule, err)
+		}
+		protected = append(protected, ProtectRule{Attribute: attribute, Pattern: pattern})
+	}
+	return protected, nil
+}
+
+// Match returns the first rule which matches the disk
+func (p ProtectedDisks) Match(disk *ghw.Disk) (ProtectRule, bool) {
+	attributes := map[string]string{
+		"serial":    disk.SerialNumber,
+		"wwn":       disk.WWN,
+		"model":     disk.Model,
+		"transport": diskTransport(disk),
+		"name":      disk.Name,
+	}
+	for _, r := range p {
+		value := attributes[r.Attribute]
+		if value == "" || value == "unknown" {
+			continue
+		}
+		if ok, _ := path.Match(r.Pattern, value); ok {
+			return r, true
+		}
+		if r.Attribute != "serial" && r.Attribute != "wwn" {
+			if ok, _ := path.Match(strings.ToLower(r.Pattern), strings.ToLower(value)); ok {
+				return r, true
+			}
+		}
+	}
+	return ProtectRule{}, false
+}
+
+// diskTransport returns the transport of the disk, the bus path tells about usb, sas, fibre channel and iscsi
+// which are all scsi disks for the kernel, e.g. pci-0000:00:14.0-usb-0:1:1.0-scsi-0:0:0:0
+func diskTransport(disk *ghw.Disk) string {
+	for _, t := range []string{"usb", "sas", "fc", "iscsi"} {
+		if strings.Contains(disk.BusPath, "-"+t+"-") || strings.HasPrefix(disk.BusPath, t+"-") {
+			return t
+		}
+	}
+	if strings.HasPrefix(disk.BusPath, "ip-") {
+		return "iscsi"
+	}
+	return strings.ToLower(disk.StorageController.String())
+}
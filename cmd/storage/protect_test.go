@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"log/slog"
+	"reflect"
+	"testing"
+
+	"github.com/jaypipes/ghw"
+)
+
+func TestProtectedDisks(t *testing.T) {
+	protected, err := ParseProtectedDisks("transport:usb, serial:S3Z9NB0K*,model:*LUN*")
+	if err != nil {
+		t.Fatalf("ParseProtectedDisks() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		disk ghw.Disk
+		want string
+	}{
+		{
+			name: "usb stick",
+			disk: ghw.Disk{Name: "sdc", Model: "Cruzer Blade", BusPath: "pci-0000:00:14.0-usb-0:1:1.0-scsi-0:0:0:0"},
+			want: "transport:usb",
+		},
+		{
+			name: "serial",
+			disk: ghw.Disk{Name: "sda", SerialNumber: "S3Z9NB0K123456", BusPath: "pci-0000:00:17.0-ata-1"},
+			want: "serial:S3Z9NB0K*",
+		},
+		{
+			name: "san lun",
+			disk: ghw.Disk{Name: "sdd", Model: "VIRTUAL-LUN", BusPath: "pci-0000:3b:00.0-fc-0x500a0981891b8dc5-lun-0"},
+			want: "model:*LUN*",
+		},
+		{
+			name: "not protected",
+			disk: ghw.Disk{Name: "nvme0n1", SerialNumber: "S4EVNF0M", Model: "SAMSUNG MZQLB960HAJR", BusPath: "pci-0000:5e:00.0-nvme-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, ok := protected.Match(&tt.disk)
+			if ok != (tt.want != "") || (ok && rule.String() != tt.want) {
+				t.Errorf("Match() = %s %v, want %q", rule, ok, tt.want)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"serial", "color:red", "model:[abc"} {
+		if _, err := ParseProtectedDisks(invalid); err == nil {
+			t.Errorf("ParseProtectedDisks(%q) expected error", invalid)
+		}
+	}
+}
+
+func TestProtectedNVMeControllers(t *testing.T) {
+	protected, err := ParseProtectedDisks("serial:S4EVNF0M*")
+	if err != nil {
+		t.Fatalf("ParseProtectedDisks() error = %v", err)
+	}
+	disks := []*ghw.Disk{
+		{Name: "nvme0n1", SerialNumber: "S4EVNF0M123"},
+		{Name: "nvme0n2", SerialNumber: "S4EVNF0M123"},
+		{Name: "nvme1n1", SerialNumber: "S4EVNF0M456"},
+		{Name: "nvme2n1", SerialNumber: "PHLJ9150"},
+		{Name: "sda", SerialNumber: "S4EVNF0M789"},
+	}
+	got := protectedNVMeControllers(disks, protected)
+	want := map[string]bool{"/dev/nvme0": true, "/dev/nvme1": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("protectedNVMeControllers() = %v, want %v", got, want)
+	}
+
+	d := NewDisks(slog.Default())
+	tests := []struct {
+		name  string
+		caps  nvmeCapabilities
+		known bool
+		want  []string
+	}{
+		{name: "format per namespace", caps: nvmeCapabilities{Sanicap: nvmeSanitizeCrypto}, known: true, want: []string{"nvme-format-namespace", "discard"}},
+		{name: "format applies to all namespaces", caps: nvmeCapabilities{FNA: nvmeFormatAllNamespaces}, known: true, want: []string{"discard"}},
+		{name: "secure erase applies to all namespaces", caps: nvmeCapabilities{FNA: nvmeEraseAllNamespaces | nvmeFormatCrypto}, known: true, want: []string{"discard"}},
+		{name: "capabilities unknown", want: []string{"discard"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, m := range d.nvmeNamespaceMethods("/dev/nvme0n2", tt.caps, tt.known, 1024) {
+				names = append(names, m.name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("nvmeNamespaceMethods() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
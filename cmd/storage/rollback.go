@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/metal-stack/metal-hammer/pkg/os"
+	"github.com/metal-stack/metal-hammer/pkg/os/command"
+)
+
+type objectKind string
+
+// executeCommand runs a command which modifies the storage, tests replace it to record the commands
+var executeCommand = os.ExecuteCommand
+
+// executeCommandOutput runs a command which modifies the storage and returns its standard output,
+// tests replace it to record the commands
+var executeCommandOutput = func(name string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to locate program:%s in path %w", name, err)
+	}
+	out, err := exec.Command(path, args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return out, fmt.Errorf("%s %v failed stderr:%s %w", name, args, strings.TrimSpace(string(exitErr.Stderr)), err)
+	}
+	return out, err
+}
+
+const (
+	kindNamespace      objectKind = "namespace"
+	kindPartitionTable objectKind = "partitiontable"
+	kindRaid           objectKind = "raid"
+	kindVolumeGroup    objectKind = "volumegroup"
+	kindLogicalVolume  objectKind = "logicalvolume"
+	kindFilesystem     objectKind = "filesystem"
+)
+
+// createdObject is a storage object which was created during Run.
+// Objects are recorded before the actual creation command is executed,
+// a failed creation might leave partial metadata behind which must be removed as well.
+type createdObject struct {
+	kind objectKind
+	// name is the device of the object, for volumegroups the name of the vg and for logical volumes vg/lv
+	name string
+	// devices the object was created on, e.g. the raid members, the physical volumes of a volumegroup or the nvme controller of a namespace
+	devices []string
+}
+
+// track records an object for the rollback. Objects below a filesystem marked with x-metal.keep are skipped,
+// e.g. wiping the partition table of a disk with a kept filesystem would lose the data which must be preserved.
+func (f *Filesystem) track(kind objectKind, name string, devices ...string) {
+	if keptDevices(f.config)[name] {
+		f.log.Info("object holds a kept filesystem, skip it on rollback", "kind", kind, "name", name)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = append(f.created, createdObject{kind: kind, name: name, devices: devices})
+}
+
+// rollback unmounts everything and removes all created objects in reverse order,
+// a subsequent Run will then start from a clean state.
+// Errors are only logged because the original error is more important.
+func (f *Filesystem) rollback() {
+	f.log.Warn("rollback storage setup", "objects", len(f.created))
+	f.umountFilesystems()
+	f.mounts = nil
+
+	for index := len(f.created) - 1; index >= 0; index-- {
+		o := f.created[index]
+		f.log.Info("rollback", "kind", o.kind, "name", o.name, "devices", o.devices)
+		err := o.remove()
+		if err != nil {
+			f.log.Error("rollback failed, ignoring", "kind", o.kind, "name", o.name, "error", err)
+		}
+	}
+	f.created = nil
+}
+
+func (o createdObject) remove() error {
+	switch o.kind {
+	case kindFilesystem:
+		return executeCommand(command.WIPEFS, "--all", o.name)
+	case kindLogicalVolume:
+		return executeCommand(command.LVM, "lvremove", "--force", "--yes", o.name)
+	case kindVolumeGroup:
+		err := executeCommand(command.LVM, "vgremove", "--force", "--yes", o.name)
+		if err != nil {
+			return err
+		}
+		args := append([]string{"pvremove", "--force", "--force", "--yes"}, o.devices...)
+		return executeCommand(command.LVM, args...)
+	case kindRaid:
+		err := executeCommand(command.MDADM, "--stop", o.name)
+		if err != nil {
+			return err
+		}
+		args := append([]string{"--zero-superblock"}, o.devices...)
+		return executeCommand(command.MDADM, args...)
+	case kindPartitionTable:
+		return executeCommand(command.WIPEFS, "--all", o.name)
+	case kindNamespace:
+		return executeCommand(command.NVME, "delete-ns", o.devices[0], "--namespace-id="+strconv.Itoa(namespaceID(o.name)))
+	default:
+		return fmt.Errorf("unknown object kind:%s", o.kind)
+	}
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/metal-stack/metal-go/api/models"
+)
+
+func TestRollback(t *testing.T) {
+	var executed []string
+	original := executeCommand
+	defer func() { executeCommand = original }()
+	executeCommand = func(name string, arg ...string) error {
+		cmd := strings.Join(append([]string{name}, arg...), " ")
+		executed = append(executed, cmd)
+		// a failing removal must not stop the rollback of the remaining objects
+		if strings.HasPrefix(cmd, "lvm lvremove") {
+			return errors.New("lv is busy")
+		}
+		return nil
+	}
+
+	f := &Filesystem{log: slog.Default()}
+	f.track(kindNamespace, "/dev/nvme0n2", "/dev/nvme0")
+	f.track(kindPartitionTable, "/dev/sda")
+	f.track(kindPartitionTable, "/dev/sdb")
+	f.track(kindRaid, "/dev/md0", "/dev/sda1", "/dev/sdb1")
+	f.track(kindVolumeGroup, "vg00", "/dev/md0")
+	f.track(kindLogicalVolume, "vg00/root")
+	f.track(kindFilesystem, "/dev/vg00/root")
+
+	f.rollback()
+
+	want := []string{
+		"wipefs --all /dev/vg00/root",
+		"lvm lvremove --force --yes vg00/root",
+		"lvm vgremove --force --yes vg00",
+		"lvm pvremove --force --force --yes /dev/md0",
+		"mdadm --stop /dev/md0",
+		"mdadm --zero-superblock /dev/sda1 /dev/sdb1",
+		"wipefs --all /dev/sdb",
+		"wipefs --all /dev/sda",
+		"nvme delete-ns /dev/nvme0 --namespace-id=2",
+	}
+	if !reflect.DeepEqual(executed, want) {
+		t.Errorf("rollback() executed\n%s\nwant\n%s", strings.Join(executed, "\n"), strings.Join(want, "\n"))
+	}
+	if f.created != nil {
+		t.Errorf("rollback() left %d tracked objects", len(f.created))
+	}
+}
+
+func TestRollbackStopsObjectOnError(t *testing.T) {
+	var executed []string
+	original := executeCommand
+	defer func() { executeCommand = original }()
+	executeCommand = func(name string, arg ...string) error {
+		executed = append(executed, strings.Join(append([]string{name}, arg...), " "))
+		return errors.New("failed")
+	}
+
+	f := &Filesystem{log: slog.Default()}
+	f.track(kindRaid, "/dev/md0", "/dev/sda1")
+	f.track(kindVolumeGroup, "vg00", "/dev/md0")
+	f.rollback()
+
+	// the second command of an object is skipped if the first fails, the next object is still removed
+	want := []string{"lvm vgremove --force --yes vg00", "mdadm --stop /dev/md0"}
+	if !reflect.DeepEqual(executed, want) {
+		t.Errorf("rollback() executed %v, want %v", executed, want)
+	}
+}
+
+func TestRollbackKeptFilesystem(t *testing.T) {
+	var executed []string
+	original := executeCommand
+	defer func() { executeCommand = original }()
+	executeCommand = func(name string, arg ...string) error {
+		executed = append(executed, strings.Join(append([]string{name}, arg...), " "))
+		return nil
+	}
+
+	ext4 := "ext4"
+	sda := "/dev/sda"
+	nvme := "/dev/nvme0n1"
+	one := int64(1)
+	varlib := "/dev/sda1"
+	root := "/dev/nvme0n1p1"
+	f := &Filesystem{
+		log: slog.Default(),
+		config: models.V1FilesystemLayoutResponse{
+			Disks: []*models.V1Disk{
+				{Device: &sda, Partitions: []*models.V1DiskPartition{{Number: &one}}},
+				{Device: &nvme, Partitions: []*models.V1DiskPartition{{Number: &one}}},
+			},
+			Filesystems: []*models.V1Filesystem{
+				{Device: &varlib, Format: &ext4, Mountoptions: []string{"x-metal.keep"}},
+				{Device: &root, Format: &ext4},
+			},
+		},
+	}
+	f.track(kindPartitionTable, sda)
+	f.track(kindPartitionTable, nvme)
+	f.track(kindFilesystem, root)
+	f.rollback()
+
+	// the partition table of the disk with the kept filesystem is not wiped
+	want := []string{"wipefs --all /dev/nvme0n1p1", "wipefs --all /dev/nvme0n1"}
+	if !reflect.DeepEqual(executed, want) {
+		t.Errorf("rollback() executed %v, want %v", executed, want)
+	}
+}
+
+func TestCreateExistingVolumeGroup(t *testing.T) {
+	vg00 := "vg00"
+	root := "root"
+	ext4 := "ext4"
+	device := "/dev/vg00/root"
+	tests := []struct {
+		name         string
+		exists       bool
+		mountoptions []string
+		want         []string
+		wantTracked  int
+	}{
+		{
+			name:        "new volumegroup",
+			want:        []string{"lvm vgcreate --verbose vg00 /dev/sda1"},
+			wantTracked: 1,
+		},
+		{
+			name:   "stale volumegroup of a failed rollback is removed",
+			exists: true,
+			want: []string{
+				"lvm vgremove --force --yes vg00",
+				"lvm pvremove --force --force --yes /dev/sda1",
+				"lvm vgcreate --verbose vg00 /dev/sda1",
+			},
+			wantTracked: 1,
+		},
+		{
+			name:         "volumegroup with a kept filesystem is reused",
+			exists:       true,
+			mountoptions: []string{"x-metal.keep"},
+		},
+	}
+	originalExecute := executeCommand
+	originalOutput := reportOutput
+	defer func() {
+		executeCommand = originalExecute
+		reportOutput = originalOutput
+	}()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var executed []string
+			executeCommand = func(name string, arg ...string) error {
+				executed = append(executed, strings.Join(append([]string{name}, arg...), " "))
+				return nil
+			}
+			reportOutput = func(name string, arg ...string) ([]byte, error) {
+				if !tt.exists {
+					return nil, errors.New("volume group not found")
+				}
+				return []byte("  vg00\n"), nil
+			}
+
+			vg := &models.V1VolumeGroup{Name: &vg00, Devices: []string{"/dev/sda1"}}
+			f := &Filesystem{
+				log:     slog.Default(),
+				pvcount: map[string]int{},
+				config: models.V1FilesystemLayoutResponse{
+					Volumegroups:   []*models.V1VolumeGroup{vg},
+					Logicalvolumes: []*models.V1LogicalVolume{{Name: &root, Volumegroup: &vg00}},
+					Filesystems:    []*models.V1Filesystem{{Device: &device, Format: &ext4, Mountoptions: tt.mountoptions}},
+				},
+			}
+			err := f.createVolumeGroup(vg)
+			if err != nil {
+				t.Fatalf("createVolumeGroup() error = %v", err)
+			}
+			if !reflect.DeepEqual(executed, tt.want) {
+				t.Errorf("createVolumeGroup() executed %v, want %v", executed, tt.want)
+			}
+			if len(f.created) != tt.wantTracked {
+				t.Errorf("createVolumeGroup() tracked %d objects, want %d", len(f.created), tt.wantTracked)
+			}
+			if f.pvcount[vg00] != 1 {
+				t.Errorf("createVolumeGroup() pvcount = %d, want 1", f.pvcount[vg00])
+			}
+		})
+	}
+}
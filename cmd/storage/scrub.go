@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	gos "os"
+
+	"github.com/jaypipes/ghw"
+	"github.com/metal-stack/metal-hammer/pkg/os/command"
+	"github.com/u-root/u-root/pkg/mount/block"
+)
+
+// tailScrubSize is zeroed at the end of the disk, it contains the backup gpt, mdadm 1.0 superblocks and lvm metadata
+const tailScrubSize = 8 * mib
+
+// releaseDevices stops the raids and device mapper devices like logical volumes which hold the disks open, it must be done
+// before the disks are wiped in parallel because they span several disks. Only stacks which are built entirely on disks
+// which are wiped are stopped, a stack which uses a protected disk keeps running.
+func (d *Disks) releaseDevices(wiped []*ghw.Disk) {
+	names := map[string]bool{}
+	for _, disk := range wiped {
+		names[disk.Name] = true
+	}
+	for _, name := range stackedDevicesToRelease(names) {
+		var err error
+		if strings.HasPrefix(name, "md") {
+			err = executeCommand(command.MDADM, "--stop", "/dev/"+name)
+		} else {
+			err = executeCommand(command.DMSetup, "remove", "--force", sysfsAttribute(name, "dm", "name"))
+		}
+		if err != nil {
+			d.log.Warn("wipe", "message", "unable to release device, ignoring", "device", name, "error", err)
+		}
+	}
+}
+
+// stackedDevicesToRelease returns the raids and device mapper devices whose member disks are all wiped,
+// a device is returned after all devices which hold it, e.g. a logical volume before the raid it is built on.
+func stackedDevicesToRelease(wiped map[string]bool) []string {
+	entries, err := gos.ReadDir(sysBlock)
+	if err != nil {
+		return nil
+	}
+	var stacked []string
+	releasable := map[string]bool{}
+	for _, e := range entries {
+		name := e.Name()
+		if !isStackedDevice(name) {
+			continue
+		}
+		stacked = append(stacked, name)
+		disks := memberDisks(name)
+		releasable[name] = len(disks) > 0
+		for _, disk := range disks {
+			if !wiped[disk] {
+				releasable[name] = false
+			}
+		}
+	}
+
+	var (
+		order    []string
+		released = map[string]bool{}
+		release  func(name string) bool
+	)
+	release = func(name string) bool {
+		if r, ok := released[name]; ok {
+			return r
+		}
+		released[name] = false
+		if !releasable[name] {
+			return false
+		}
+		for _, holder := range holderDevices(name) {
+			if !release(holder) {
+				return false
+			}
+		}
+		released[name] = true
+		order = append(order, name)
+		return true
+	}
+	for _, name := range stacked {
+		release(name)
+	}
+	return order
+}
+
+// isStackedDevice returns true for raids and device mapper devices, but not for their partitions
+func isStackedDevice(name string) bool {
+	return (strings.HasPrefix(name, "md") || strings.HasPrefix(name, "dm-")) && sysfsAttribute(name, "partition") == ""
+}
+
+// memberDisks returns the disks a stacked device is built on, partitions and stacked members are resolved to their disks
+func memberDisks(name string) []string {
+	var disks []string
+	for _, slave := range sysfsEntries(name, "slaves") {
+		disk := parentDisk(slave)
+		if isStackedDevice(disk) {
+			disks = append(disks, memberDisks(disk)...)
+			continue
+		}
+		disks = append(disks, disk)
+	}
+	return disks
+}
+
+// holderDevices returns the devices which hold the device or one of its partitions
+func holderDevices(name string) []string {
+	holders := sysfsEntries(name, "holders")
+	for _, e := range sysfsEntries(name) {
+		if sysfsAttribute(filepath.Join(name, e), "partition") != "" {
+			holders = append(holders, sysfsEntries(name, e, "holders")...)
+		}
+	}
+	return holders
+}
+
+// parentDisk returns the disk of a partition, the parent directory of a partition in sysfs is its disk
+func parentDisk(name string) string {
+	if sysfsAttribute(name, "partition") == "" {
+		return name
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(sysBlock, name))
+	if err != nil {
+		return name
+	}
+	return filepath.Base(filepath.Dir(resolved))
+}
+
+// sysfsEntries returns the names of the entries of a directory of a block device in sysfs
+func sysfsEntries(name string, dir ...string) []string {
+	entries, err := gos.ReadDir(filepath.Join(append([]string{sysBlock, name}, dir...)...))
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// scrubMetadata removes md superblocks, lvm physical volumes and all signatures from the partitions and the disk.
+// Errors are ignored, most of the devices do not contain any metadata.
+func (d *Disks) scrubMetadata(disk *ghw.Disk) {
+	var devices []string
+	for _, p := range disk.Partitions {
+		devices = append(devices, "/dev/"+p.Name)
+	}
+	devices = append(devices, "/dev/"+disk.Name)
+
+	for _, device := range devices {
+		for _, c := range [][]string{
+			{command.MDADM, "--zero-superblock", "--force", device},
+			{command.LVM, "pvremove", "--force", "--force", "--yes", device},
+			{command.WIPEFS, "--all", "--force", device},
+		} {
+			err := executeCommand(c[0], c[1:]...)
+			if err != nil {
+				d.log.Debug("wipe", "disk", device, "message", "scrub metadata", "command", c, "error", err)
+			}
+		}
+	}
+	d.rereadPartitionTable("/dev/" + disk.Name)
+}
+
+// zeroTail overwrites the end of the disk where the backup gpt and mdadm 1.0 superblocks reside
+func zeroTail(device string, size uint64) error {
+	length := min(tailScrubSize, size)
+	f, err := gos.OpenFile(device, gos.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteAt(make([]byte, length), int64(size-length)) // nolint:gosec
+	if err != nil {
+		return fmt.Errorf("unable to zero the last %d MiB of %s %w", length/mib, device, err)
+	}
+	return f.Sync()
+}
+
+// remainingSignatures returns all filesystem, raid and partition table signatures found by wipefs
+func remainingSignatures(device string) (string, error) {
+	out, err := reportOutput(command.WIPEFS, "--noheadings", device)
+	if err != nil {
+		return "", fmt.Errorf("unable to list signatures of %s %w", device, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (d *Disks) rereadPartitionTable(device string) {
+	blkdev, err := block.Device(device)
+	if err != nil {
+		d.log.Warn("wipe", "disk", device, "message", "unable to find block device", "error", err)
+		return
+	}
+	err = blkdev.ReadPartitionTable()
+	if err != nil {
+		d.log.Warn("wipe", "disk", device, "message", "unable to re-read partition table", "error", err)
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jaypipes/ghw"
+)
+
+func TestZeroTail(t *testing.T) {
+	size := 16 * mib
+	device := filepath.Join(t.TempDir(), "disk")
+	err := os.WriteFile(device, bytes.Repeat([]byte{0xaa}, int(size)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = zeroTail(device, size)
+	if err != nil {
+		t.Fatalf("zeroTail() error = %v", err)
+	}
+
+	content, err := os.ReadFile(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, tail := content[:size-tailScrubSize], content[size-tailScrubSize:]
+	if !bytes.Equal(head, bytes.Repeat([]byte{0xaa}, len(head))) {
+		t.Errorf("zeroTail() must not touch the beginning of the disk")
+	}
+	if !bytes.Equal(tail, make([]byte, len(tail))) {
+		t.Errorf("zeroTail() did not zero the end of the disk")
+	}
+}
+
+func TestReleaseDevices(t *testing.T) {
+	root := t.TempDir()
+	original := sysBlock
+	defer func() { sysBlock = original }()
+	sysBlock = filepath.Join(root, "class", "block")
+	devices := filepath.Join(root, "devices")
+
+	// md0 on sda1 and sdb1 with the logical volume dm-0, dm-1 on the protected sdc,
+	// dm-2 spans md1 on sdd1 and the protected sdc and keeps md1 busy
+	dirs := []string{
+		"sda/sda1/holders/md0", "sdb/sdb1/holders/md0", "sdc/sdc1/holders/dm-1", "sdc/sdc2/holders/dm-2", "sdd/sdd1/holders/md1",
+		"md0/slaves/sda1", "md0/slaves/sdb1", "md0/holders/dm-0",
+		"md1/slaves/sdd1", "md1/md1p1/holders/dm-2",
+		"dm-0/slaves/md0", "dm-0/holders",
+		"dm-1/slaves/sdc1", "dm-1/holders",
+		"dm-2/slaves/md1p1", "dm-2/slaves/sdc2", "dm-2/holders",
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(devices, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"sda/sda1/partition":  "1",
+		"sdb/sdb1/partition":  "1",
+		"sdc/sdc1/partition":  "1",
+		"sdc/sdc2/partition":  "2",
+		"sdd/sdd1/partition":  "1",
+		"md1/md1p1/partition": "1",
+		"dm-0/dm/name":        "vg00-root",
+		"dm-1/dm/name":        "vg01-data",
+		"dm-2/dm/name":        "vg02-data",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(devices, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(devices, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(sysBlock, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, dir := range map[string]string{
+		"sda": "sda", "sda1": "sda/sda1", "sdb": "sdb", "sdb1": "sdb/sdb1", "sdc": "sdc", "sdc1": "sdc/sdc1", "sdc2": "sdc/sdc2",
+		"sdd": "sdd", "sdd1": "sdd/sdd1", "md0": "md0", "md1": "md1", "md1p1": "md1/md1p1", "dm-0": "dm-0", "dm-1": "dm-1", "dm-2": "dm-2",
+	} {
+		if err := os.Symlink(filepath.Join(devices, dir), filepath.Join(sysBlock, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var executed []string
+	originalExecute := executeCommand
+	defer func() { executeCommand = originalExecute }()
+	executeCommand = func(name string, arg ...string) error {
+		executed = append(executed, strings.Join(append([]string{name}, arg...), " "))
+		return nil
+	}
+
+	d := NewDisks(slog.Default())
+	d.releaseDevices([]*ghw.Disk{{Name: "sda"}, {Name: "sdb"}, {Name: "sdd"}})
+
+	want := []string{
+		"dmsetup remove --force vg00-root",
+		"mdadm --stop /dev/md0",
+	}
+	if !reflect.DeepEqual(executed, want) {
+		t.Errorf("releaseDevices() executed %v, want %v", executed, want)
+	}
+}
+
+func TestScrubMetadata(t *testing.T) {
+	var executed []string
+	originalExecute := executeCommand
+	defer func() { executeCommand = originalExecute }()
+	executeCommand = func(name string, arg ...string) error {
+		executed = append(executed, strings.Join(append([]string{name}, arg...), " "))
+		return nil
+	}
+
+	d := NewDisks(slog.Default())
+	d.scrubMetadata(&ghw.Disk{Name: "testdisk", Partitions: []*ghw.Partition{{Name: "testdisk1"}}})
+
+	// partitions are scrubbed before the disk, their metadata is not reachable after the partition table is gone
+	want := []string{
+		"mdadm --zero-superblock --force /dev/testdisk1",
+		"lvm pvremove --force --force --yes /dev/testdisk1",
+		"wipefs --all --force /dev/testdisk1",
+		"mdadm --zero-superblock --force /dev/testdisk",
+		"lvm pvremove --force --force --yes /dev/testdisk",
+		"wipefs --all --force /dev/testdisk",
+	}
+	if !reflect.DeepEqual(executed, want) {
+		t.Errorf("scrubMetadata() executed %v, want %v", executed, want)
+	}
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"fmt"
+	gos "os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal-stack/metal-hammer/pkg/os"
+	"github.com/metal-stack/metal-hammer/pkg/os/command"
+)
+
+const (
+	// scsiOpcodeSanitize is the opcode of SANITIZE, the service action selects the kind of sanitize
+	scsiOpcodeSanitize = "0x48"
+	// scsiOpcodeFormatUnit is the opcode of FORMAT UNIT
+	scsiOpcodeFormatUnit = "0x04"
+
+	scsiPollInterval = 30 * time.Second
+	// scsiTimeout is long because overwrite and format of large hdds take many hours
+	scsiTimeout = 48 * time.Hour
+
+	// scsiOverwritePatternSize is the size of the pattern written by a sanitize overwrite, it must not exceed the logical block size
+	scsiOverwritePatternSize = 512
+)
+
+var (
+	// Progress indication: 12.34% done
+	scsiProgressRegex = regexp.MustCompile(`Progress indication:\s*([0-9.]+)% done`)
+	// scsiFailedSenses are the additional sense codes 0x31 which a disk reports after a failed sanitize or format
+	scsiFailedSenses = []string{"Medium format corrupted", "Format command failed", "Sanitize command failed"}
+)
+
+// scsiSanitize is a kind of SANITIZE with its service action and sg_sanitize argument
+type scsiSanitize struct {
+	name          string
+	serviceAction string
+	arg           string
+	// crypto is verified by the sense data only, self encrypting hdds return random data afterwards
+	crypto bool
+}
+
+var scsiSanitizes = []scsiSanitize{
+	{name: "scsi-sanitize-crypto", serviceAction: "3", arg: "--crypto", crypto: true},
+	{name: "scsi-sanitize-block", serviceAction: "2", arg: "--block"},
+	{name: "scsi-sanitize-overwrite", serviceAction: "1", arg: "--overwrite"},
+}
+
+// isSCSIDisk returns true for disks attached with a scsi transport like sas, sata disks are handled by ata
+func isSCSIDisk(deviceName string) bool {
+	return strings.HasPrefix(deviceName, "sd") && !isATADisk(deviceName)
+}
+
+// scsiMethods returns the sanitize and format methods which are supported by the disk
+func (d *Disks) scsiMethods(device string, bytes uint64) []wipeMethod {
+	var methods []wipeMethod
+	for _, s := range scsiSanitizes {
+		if !scsiSupports(device, scsiOpcodeSanitize, s.serviceAction) {
+			continue
+		}
+		methods = append(methods, wipeMethod{name: s.name, crypto: s.crypto, erase: func() error { return d.sanitizeSCSI(device, s.arg, bytes) }})
+	}
+	if scsiSupports(device, scsiOpcodeFormatUnit, "") {
+		methods = append(methods, wipeMethod{name: "scsi-format", erase: func() error { return d.formatSCSI(device, bytes) }})
+	}
+	d.log.Info("wipe", "disk", device, "scsi methods", len(methods))
+	return methods
+}
+
+// scsiSupports asks the disk with REPORT SUPPORTED OPERATION CODES if the command is supported
+func scsiSupports(device, opcode, serviceAction string) bool {
+	args := []string{"--no-inquiry", "--opcode=" + opcode}
+	if serviceAction != "" {
+		args = append(args, "--sa="+serviceAction)
+	}
+	args = append(args, device)
+	out, err := reportOutput(command.SGOpcodes, args...)
+	if err != nil {
+		return false
+	}
+	return scsiCommandSupported(string(out))
+}
+
+// scsiCommandSupported parses the output of sg_opcodes for a single command:
+//
+//	Opcode=0x48  Service_action=0x0003
+//	  Command_name: Sanitize, cryptographic erase
+//	  Command supported [conforming to SCSI standard]
+func scsiCommandSupported(output string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Command supported") {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeSCSI starts the sanitize in the background and waits until the disk reports no more progress
+func (d *Disks) sanitizeSCSI(device, arg string, bytes uint64) error {
+	d.log.Info("wipe", "disk", device, "message", "start scsi sanitize", "kind", arg)
+	args := []string{"--early", "--quick", arg}
+	if arg == "--overwrite" {
+		// overwrite requires a pattern which is written to every block
+		pattern, err := scsiOverwritePattern()
+		if err != nil {
+			return err
+		}
+		defer gos.Remove(pattern)
+		args = append(args, "--pattern="+pattern)
+	}
+	err := os.ExecuteCommand(command.SGSanitize, append(args, device)...)
+	if err != nil {
+		return fmt.Errorf("unable to start sanitize of %s %w", device, err)
+	}
+	return d.waitSCSI(device, d.newProgressReporter(device, "scsi-sanitize", bytes))
+}
+
+// scsiOverwritePattern writes a pattern of zeros to a temporary file and returns its path
+func scsiOverwritePattern() (string, error) {
+	f, err := gos.CreateTemp("", "sanitize-pattern")
+	if err != nil {
+		return "", fmt.Errorf("unable to create sanitize pattern %w", err)
+	}
+	defer f.Close()
+	_, err = f.Write(make([]byte, scsiOverwritePatternSize))
+	if err != nil {
+		return "", fmt.Errorf("unable to write sanitize pattern %w", err)
+	}
+	return f.Name(), nil
+}
+
+// formatSCSI starts a FORMAT UNIT in the background and waits until the disk reports no more progress
+func (d *Disks) formatSCSI(device string, bytes uint64) error {
+	d.log.Info("wipe", "disk", device, "message", "start scsi format unit")
+	err := os.ExecuteCommand(command.SGFormat, "--format", "--early", "--quick", device)
+	if err != nil {
+		return fmt.Errorf("unable to start format of %s %w", device, err)
+	}
+	return d.waitSCSI(device, d.newProgressReporter(device, "scsi-format", bytes))
+}
+
+// waitSCSI polls the progress with REQUEST SENSE, the disk reports progress as long as the operation is running
+func (d *Disks) waitSCSI(device string, reporter *progressReporter) error {
+	path, err := exec.LookPath(command.SGRequests)
+	if err != nil {
+		return fmt.Errorf("unable to locate program:%s in path %w", command.SGRequests, err)
+	}
+	start := time.Now()
+	for {
+		time.Sleep(scsiPollInterval)
+		out, err := exec.Command(path, "--progress", device).Output() // nolint:gosec
+		if err != nil {
+			return fmt.Errorf("unable to read progress of %s %w", device, err)
+		}
+		progress, running := parseSCSIProgress(string(out))
+		if !running {
+			// the sense data tells whether the operation succeeded after the progress is gone
+			out, err := exec.Command(path, device).Output() // nolint:gosec
+			if err != nil {
+				return fmt.Errorf("unable to read sense data of %s %w", device, err)
+			}
+			err = scsiSenseFailure(string(out))
+			if err != nil {
+				return fmt.Errorf("erase of %s failed %w", device, err)
+			}
+			d.log.Info("wipe", "disk", device, "message", "scsi erase finished", "took", time.Since(start))
+			return nil
+		}
+		reporter.updatePercent(progress)
+		if time.Since(start) > scsiTimeout {
+			return fmt.Errorf("erase of %s not finished after %s", device, scsiTimeout)
+		}
+	}
+}
+
+// parseSCSIProgress returns the progress reported by sg_requests --progress, running is false if no progress is reported anymore
+func parseSCSIProgress(output string) (float64, bool) {
+	m := scsiProgressRegex.FindStringSubmatch(output)
+	if m == nil {
+		return 100, false
+	}
+	progress, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, true
+	}
+	return progress, true
+}
+
+// scsiSenseFailure returns an error if the sense data reported by sg_requests indicates a failed sanitize or format:
+//
+//	Decode parameter data as sense data:
+//	Fixed format, current; Sense key: Medium Error
+//	Additional sense: Sanitize command failed
+func scsiSenseFailure(output string) error {
+	for _, line := range strings.Split(output, "\n") {
+		sense, ok := strings.CutPrefix(strings.TrimSpace(line), "Additional sense:")
+		if !ok {
+			continue
+		}
+		sense = strings.TrimSpace(sense)
+		for _, failed := range scsiFailedSenses {
+			if strings.EqualFold(sense, failed) {
+				return fmt.Errorf("disk reports %q", sense)
+			}
+		}
+	}
+	return nil
+}
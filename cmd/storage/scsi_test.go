@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jaypipes/ghw"
+)
+
+func TestSCSIOutput(t *testing.T) {
+	supported := `  Opcode=0x48  Service_action=0x0003
+  Command_name: Sanitize, cryptographic erase
+  Command supported [conforming to SCSI standard]
+`
+	if !scsiCommandSupported(supported) {
+		t.Errorf("scsiCommandSupported() = false, want true")
+	}
+	notSupported := `  Opcode=0x48  Service_action=0x0003
+  Command_name: Sanitize, cryptographic erase
+  Command not supported
+`
+	if scsiCommandSupported(notSupported) {
+		t.Errorf("scsiCommandSupported() = true, want false")
+	}
+
+	progress, running := parseSCSIProgress("Decode parameter data as sense data:\n Progress indication: 42.17% done\n")
+	if !running || progress != 42.17 {
+		t.Errorf("parseSCSIProgress() = %v %v, want 42.17 true", progress, running)
+	}
+	_, running = parseSCSIProgress("Decode parameter data as sense data:\n No additional sense information\n")
+	if running {
+		t.Errorf("parseSCSIProgress() = running, want finished")
+	}
+
+	failed := "Decode parameter data as sense data:\nFixed format, current; Sense key: Medium Error\nAdditional sense: Sanitize command failed\n"
+	if err := scsiSenseFailure(failed); err == nil {
+		t.Errorf("scsiSenseFailure() = nil, want error for failed sanitize")
+	}
+	formatFailed := "Fixed format, current; Sense key: Medium Error\n Additional sense: Medium format corrupted\n"
+	if err := scsiSenseFailure(formatFailed); err == nil {
+		t.Errorf("scsiSenseFailure() = nil, want error for corrupted format")
+	}
+	if err := scsiSenseFailure("Decode parameter data as sense data:\nFixed format, current; Sense key: No Sense\nAdditional sense: No additional sense information\n"); err != nil {
+		t.Errorf("scsiSenseFailure() = %v, want nil", err)
+	}
+}
+
+func TestMethodsRotationalSAS(t *testing.T) {
+	original := sysBlock
+	defer func() { sysBlock = original }()
+	sysBlock = t.TempDir()
+	for file, content := range map[string]string{
+		"sdb/queue/rotational": "1\n",
+		"sdb/device/vendor":    "SEAGATE \n",
+	} {
+		path := filepath.Join(sysBlock, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a self encrypting sas hdd supports the crypto and overwrite sanitize and format unit, but no block erase
+	supported := map[string]bool{
+		"--opcode=0x48 --sa=3": true,
+		"--opcode=0x48 --sa=1": true,
+		"--opcode=0x04":        true,
+	}
+	originalOutput := reportOutput
+	defer func() { reportOutput = originalOutput }()
+	reportOutput = func(name string, args ...string) ([]byte, error) {
+		if supported[strings.Join(args[1:len(args)-1], " ")] {
+			return []byte("  Command supported [conforming to SCSI standard]\n"), nil
+		}
+		return []byte("  Command not supported\n"), nil
+	}
+
+	d := NewDisks(slog.Default())
+	var (
+		names  []string
+		crypto []bool
+	)
+	for _, m := range d.methods(&ghw.Disk{Name: "sdb"}) {
+		names = append(names, m.name)
+		crypto = append(crypto, m.crypto)
+	}
+	// the crypto sanitize is verified by the sense data, the overwrite is only used if it fails
+	want := []string{"scsi-sanitize-crypto", "scsi-sanitize-overwrite", "scsi-format", "overwrite"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("methods() = %v, want %v", names, want)
+	}
+	wantCrypto := []bool{true, false, false, false}
+	if !reflect.DeepEqual(crypto, wantCrypto) {
+		t.Errorf("methods() crypto = %v, want %v", crypto, wantCrypto)
+	}
+}
@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"fmt"
+	"log/slog"
+	gos "os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal-stack/metal-hammer/pkg/os"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// settleTimeout is the maximum time to wait for the kernel to create a device
+	settleTimeout = 30 * time.Second
+	// settleInterval is the time between two checks for a device
+	settleInterval = 100 * time.Millisecond
+	// diskLinks is the directory where by-id, by-uuid, by-partuuid, by-label and by-partlabel links are created
+	diskLinks = "/dev/disk"
+)
+
+var (
+	sysBlock    = "/sys/class/block"
+	sysDevBlock = "/sys/dev/block"
+	devDir      = "/dev"
+)
+
+// waitForDevices waits until all given devices are present in sysfs and have a device node.
+// There is no udev in the initrd, missing device nodes are created from the major:minor numbers in sysfs,
+// symlinks like /dev/vg/lv or /dev/md/name are resolved once they are created by lvm or mdadm.
+func waitForDevices(log *slog.Logger, timeout time.Duration, devices ...string) error {
+	start := time.Now()
+	for _, device := range devices {
+		for {
+			err := settleDevice(device)
+			if err == nil {
+				break
+			}
+			if time.Since(start) > timeout {
+				return fmt.Errorf("device %s not present after %s %w", device, timeout, err)
+			}
+			time.Sleep(settleInterval)
+		}
+	}
+	log.Info("devices settled", "devices", devices, "took", time.Since(start))
+	return nil
+}
+
+// settleDevice returns nil if the device is present in sysfs and has a device node
+func settleDevice(device string) error {
+	name := device
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		name = resolved
+	}
+	sysfs, err := sysfsBlockDevice(name)
+	if err != nil {
+		return err
+	}
+	dev, err := gos.ReadFile(filepath.Join(sysfs, "dev"))
+	if err != nil {
+		return err
+	}
+	if _, err := gos.Stat(name); err == nil {
+		return nil
+	}
+	if filepath.Dir(name) != devDir {
+		// symlinks are created by the tool which created the device, e.g. lvm
+		return fmt.Errorf("device %s has no device node yet", device)
+	}
+	major, minor, err := parseMajorMinor(string(dev))
+	if err != nil {
+		return err
+	}
+	err = unix.Mknod(name, unix.S_IFBLK|0660, int(unix.Mkdev(major, minor))) // nolint:gosec
+	if err != nil && !gos.IsExist(err) {
+		return fmt.Errorf("unable to create device node %s %w", name, err)
+	}
+	return nil
+}
+
+// sysfsBlockDevice returns the sysfs directory of a block device. Device mapper devices like /dev/mapper/vg00-root
+// are only known by their kernel name dm-N in sysfs, they are found by the major:minor of the device node
+// or by the name of the mapping if the device node does not exist yet.
+func sysfsBlockDevice(name string) (string, error) {
+	dir := filepath.Join(sysBlock, filepath.Base(name))
+	if _, err := gos.Stat(dir); err == nil {
+		return dir, nil
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(name, &st); err == nil && st.Mode&unix.S_IFMT == unix.S_IFBLK {
+		return filepath.Join(sysDevBlock, fmt.Sprintf("%d:%d", unix.Major(st.Rdev), unix.Minor(st.Rdev))), nil
+	}
+	if filepath.Dir(name) == filepath.Join(devDir, "mapper") {
+		names, _ := filepath.Glob(filepath.Join(sysBlock, "dm-*", "dm", "name"))
+		for _, n := range names {
+			content, err := gos.ReadFile(n)
+			if err == nil && strings.TrimSpace(string(content)) == filepath.Base(name) {
+				return filepath.Dir(filepath.Dir(n)), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("device %s is not present in sysfs", name)
+}
+
+// parseMajorMinor parses the content of a sysfs dev file, e.g. 259:1
+func parseMajorMinor(dev string) (uint32, uint32, error) {
+	ma, mi, ok := strings.Cut(strings.TrimSpace(dev), ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid device number %q", dev)
+	}
+	major, err := strconv.ParseUint(ma, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid major number %q %w", dev, err)
+	}
+	minor, err := strconv.ParseUint(mi, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid minor number %q %w", dev, err)
+	}
+	return uint32(major), uint32(minor), nil
+}
+
+// createDeviceLinks creates the /dev/disk/by-* symlinks for all block devices like udev does,
+// installers and fstab entries which reference devices by uuid or label rely on them.
+// Errors are only logged, a missing link must not break the installation.
+func (f *Filesystem) createDeviceLinks() {
+	entries, err := gos.ReadDir(sysBlock)
+	if err != nil {
+		f.log.Error("unable to list block devices, no device links created", "error", err)
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		device := filepath.Join(devDir, name)
+		links := deviceLinks(name, sysfsAttribute)
+
+		props, err := FetchBlockIDProperties(device)
+		if err == nil {
+			links = append(links, blkidLinks(props)...)
+		}
+		for _, link := range links {
+			err := createDeviceLink(device, filepath.Join(diskLinks, link))
+			if err != nil {
+				f.log.Warn("unable to create device link", "device", device, "link", link, "error", err)
+			}
+		}
+	}
+}
+
+// sysfsAttribute returns the trimmed content of the given attribute of a block device
+func sysfsAttribute(name string, attribute ...string) string {
+	return os.ReadSysfs(append([]string{sysBlock, name}, attribute...)...)
+}
+
+// deviceLinks returns the by-id links of a block device read from sysfs
+func deviceLinks(name string, attr func(name string, attribute ...string) string) []string {
+	var links []string
+	disk := name
+	partition := attr(name, "partition")
+	if partition != "" {
+		// the parent directory of a partition in sysfs is its disk
+		parent, err := filepath.EvalSymlinks(filepath.Join(sysBlock, name))
+		if err != nil {
+			return nil
+		}
+		disk = filepath.Base(filepath.Dir(parent))
+	}
+	suffix := ""
+	if partition != "" {
+		suffix = "-part" + partition
+	}
+
+	switch {
+	case strings.HasPrefix(disk, "md"):
+		if uuid := attr(disk, "md", "uuid"); uuid != "" {
+			links = append(links, filepath.Join("by-id", "md-uuid-"+uuid+suffix))
+		}
+	case strings.HasPrefix(disk, "dm-"):
+		if dmName := attr(disk, "dm", "name"); dmName != "" {
+			links = append(links, filepath.Join("by-id", "dm-name-"+dmName))
+		}
+		if dmUUID := attr(disk, "dm", "uuid"); dmUUID != "" {
+			links = append(links, filepath.Join("by-id", "dm-uuid-"+dmUUID))
+		}
+	default:
+		wwid := attr(disk, "wwid")
+		if wwid == "" {
+			wwid = attr(disk, "device", "wwid")
+		}
+		if wwid == "" {
+			break
+		}
+		// nvme reports eui.xxx or nvme.xxx, scsi and ata naa.xxx which udev links as wwn-0x
+		if strings.HasPrefix(disk, "nvme") {
+			links = append(links, filepath.Join("by-id", "nvme-"+encodeDeviceLinkName(wwid)+suffix))
+		} else if id, ok := strings.CutPrefix(wwid, "naa."); ok {
+			links = append(links, filepath.Join("by-id", "wwn-0x"+id+suffix))
+		}
+	}
+	return links
+}
+
+// blkidLinks returns the by-uuid, by-partuuid, by-label and by-partlabel links for the given blkid properties
+func blkidLinks(props map[string]string) []string {
+	var links []string
+	for _, l := range []struct {
+		dir, key string
+	}{
+		{dir: "by-uuid", key: "UUID"},
+		{dir: "by-partuuid", key: "PARTUUID"},
+		{dir: "by-label", key: "LABEL"},
+		{dir: "by-partlabel", key: "PARTLABEL"},
+	} {
+		value := props[l.key]
+		if value == "" {
+			continue
+		}
+		// blkid -o export escapes spaces with a backslash
+		value = strings.ReplaceAll(value, `\ `, " ")
+		links = append(links, filepath.Join(l.dir, encodeDeviceLinkName(value)))
+	}
+	return links
+}
+
+// encodeDeviceLinkName encodes characters which are not allowed in a link name the same way udev does, e.g. a space becomes \x20
+func encodeDeviceLinkName(name string) string {
+	var sb strings.Builder
+	for _, c := range []byte(name) {
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', strings.IndexByte("#+-.:=@_", c) >= 0:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		}
+	}
+	return sb.String()
+}
+
+// createDeviceLink creates or replaces a relative symlink to the device
+func createDeviceLink(device, link string) error {
+	err := gos.MkdirAll(filepath.Dir(link), 0755)
+	if err != nil {
+		return err
+	}
+	target, err := filepath.Rel(filepath.Dir(link), device)
+	if err != nil {
+		return err
+	}
+	if existing, err := gos.Readlink(link); err == nil {
+		if existing == target {
+			return nil
+		}
+		err = gos.Remove(link)
+		if err != nil {
+			return err
+		}
+	}
+	return gos.Symlink(target, link)
+}
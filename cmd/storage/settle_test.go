@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDeviceLinkName(t *testing.T) {
+	tests := map[string]string{
+		"root":                 "root",
+		"EFI System Partition": `EFI\x20System\x20Partition`,
+		"a/b":                  `a\x2fb`,
+		"eui.0025388b91c0e2a1": "eui.0025388b91c0e2a1",
+	}
+	for name, want := range tests {
+		if got := encodeDeviceLinkName(name); got != want {
+			t.Errorf("encodeDeviceLinkName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestBlkidLinks(t *testing.T) {
+	props := map[string]string{
+		"DEVNAME":   "/dev/sda1",
+		"UUID":      "E562-31F0",
+		"TYPE":      "vfat",
+		"LABEL":     "efi",
+		"PARTLABEL": `EFI\ System\ Partition`,
+		"PARTUUID":  "5995932d-c5ba-43db-bd4b-53564510720",
+	}
+	want := []string{
+		"by-uuid/E562-31F0",
+		"by-partuuid/5995932d-c5ba-43db-bd4b-53564510720",
+		"by-label/efi",
+		`by-partlabel/EFI\x20System\x20Partition`,
+	}
+	if got := blkidLinks(props); !reflect.DeepEqual(got, want) {
+		t.Errorf("blkidLinks() = %v, want %v", got, want)
+	}
+}
+
+func TestDeviceLinks(t *testing.T) {
+	attrs := map[string]string{
+		"sda/device/wwid": "naa.5000c500a1b2c3d4",
+		"nvme0n1/wwid":    "eui.0025388b91c0e2a1",
+		"md1/md/uuid":     "2e4d5be8:54f2a7e6:d6a8f9e8:1c8dc5a4",
+		"dm-0/dm/name":    "vg00-root",
+		"dm-0/dm/uuid":    "LVM-abc",
+	}
+	attr := func(name string, attribute ...string) string {
+		key := name
+		for _, a := range attribute {
+			key += "/" + a
+		}
+		return attrs[key]
+	}
+	tests := map[string][]string{
+		"sda":     {"by-id/wwn-0x5000c500a1b2c3d4"},
+		"nvme0n1": {"by-id/nvme-eui.0025388b91c0e2a1"},
+		"md1":     {"by-id/md-uuid-2e4d5be8:54f2a7e6:d6a8f9e8:1c8dc5a4"},
+		"dm-0":    {"by-id/dm-name-vg00-root", "by-id/dm-uuid-LVM-abc"},
+		"loop0":   nil,
+	}
+	for name, want := range tests {
+		if got := deviceLinks(name, attr); !reflect.DeepEqual(got, want) {
+			t.Errorf("deviceLinks(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// fakeBlockDevices creates a sysfs and /dev tree with a disk partition and a logical volume,
+// the logical volume is only known as dm-0 in sysfs and linked by lvm as /dev/vg00/root to /dev/mapper/vg00-root
+func fakeBlockDevices(t *testing.T) {
+	t.Helper()
+	root := t.TempDir()
+	originalSysBlock, originalDevDir := sysBlock, devDir
+	t.Cleanup(func() { sysBlock, devDir = originalSysBlock, originalDevDir })
+	sysBlock = filepath.Join(root, "sys", "class", "block")
+	devDir = filepath.Join(root, "dev")
+
+	files := map[string]string{
+		filepath.Join(sysBlock, "sda1", "dev"):          "8:1\n",
+		filepath.Join(sysBlock, "sda1", "size"):         "2048\n",
+		filepath.Join(sysBlock, "dm-0", "dev"):          "253:0\n",
+		filepath.Join(sysBlock, "dm-0", "size"):         "4194304\n",
+		filepath.Join(sysBlock, "dm-0", "dm", "name"):   "vg00-root\n",
+		filepath.Join(devDir, "sda1"):                   "",
+		filepath.Join(devDir, "mapper", "vg00-root"):    "",
+		filepath.Join(sysBlock, "dm-1", "dm", "name"):   "vg00-swap\n",
+		filepath.Join(sysBlock, "dm-1", "size"):         "1024\n",
+		filepath.Join(devDir, "mapper", "other-volume"): "",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(devDir, "vg00"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../mapper/vg00-root", filepath.Join(devDir, "vg00", "root")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSettleDevice(t *testing.T) {
+	fakeBlockDevices(t)
+	tests := []struct {
+		device  string
+		wantErr bool
+	}{
+		{device: filepath.Join(devDir, "sda1")},
+		{device: filepath.Join(devDir, "vg00", "root")},
+		{device: filepath.Join(devDir, "mapper", "vg00-root")},
+		{device: filepath.Join(devDir, "vg00", "swap"), wantErr: true},
+		{device: filepath.Join(devDir, "mapper", "other-volume"), wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := settleDevice(tt.device); (err != nil) != tt.wantErr {
+			t.Errorf("settleDevice(%q) error = %v, wantErr %v", tt.device, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSysfsDeviceSize(t *testing.T) {
+	fakeBlockDevices(t)
+	tests := []struct {
+		device string
+		want   uint64
+		wantOk bool
+	}{
+		{device: filepath.Join(devDir, "sda1"), want: 1024 * 1024, wantOk: true},
+		{device: filepath.Join(devDir, "vg00", "root"), want: 2 * 1024 * 1024 * 1024, wantOk: true},
+		{device: filepath.Join(devDir, "mapper", "other-volume")},
+		{device: filepath.Join(devDir, "sdb")},
+	}
+	for _, tt := range tests {
+		got, ok := sysfsDeviceSize(tt.device)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("sysfsDeviceSize(%q) = %d, %v, want %d, %v", tt.device, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	gos "os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/metal-stack/metal-go/api/models"
+)
+
+const (
+	// mib is the unit in which partition and logical volume sizes are given in the layout
+	mib = uint64(1024 * 1024)
+	// gptOverhead is reserved for partition alignment at the start and the backup gpt at the end of a disk
+	gptOverhead = 2 * mib
+)
+
+var (
+	// supportedFormats must match the formats handled in createFilesystems and mountFs
+	supportedFormats = []string{"ext3", "ext4", "swap", "vfat", "tmpfs", "none"}
+
+	// raidMinimumDevices is the minimum number of active devices for a given raid level
+	raidMinimumDevices = map[string]int{
+		"0":  2,
+		"1":  2,
+		"4":  3,
+		"5":  3,
+		"6":  4,
+		"10": 2,
+	}
+)
+
+// deviceSizeFunc returns the size in bytes of the given device and whether it exists
+type deviceSizeFunc func(device string) (uint64, bool)
+
+// Validate checks the filesystem layout against the detected hardware before anything is touched.
+// All problems found are returned at once. The capacity of nvme controllers on which namespaces will be created
+// is read with nvme id-ctrl, id-ns and list-ns, these commands only read the identify data of the controller.
+func (f *Filesystem) Validate() error {
+	plans, err := planNamespaces(f.config, deviceExists, allocatedNamespaces)
+	if err != nil {
+		return fmt.Errorf("filesystem layout %q is invalid for this machine: %w", layoutName(f.config), err)
+	}
+	deviceSize, err := namespaceDeviceSize(plans, sysfsDeviceSize)
+	if err != nil {
+		return fmt.Errorf("filesystem layout %q is invalid for this machine: %w", layoutName(f.config), err)
+	}
+	errs := validateLayout(f.config, deviceSize)
+	if len(errs) > 0 {
+		return fmt.Errorf("filesystem layout %q is invalid for this machine: %w", layoutName(f.config), errors.Join(errs...))
+	}
+	return nil
+}
+
+func validateLayout(config models.V1FilesystemLayoutResponse, deviceSize deviceSizeFunc) []error {
+	var (
+		errs []error
+		// devices contains every device which is created by the layout
+		devices = map[string]bool{}
+	)
+
+	known := func(device string) bool {
+		if devices[device] {
+			return true
+		}
+		_, ok := deviceSize(device)
+		return ok
+	}
+
+	for _, disk := range config.Disks {
+		if disk.Device == nil || *disk.Device == "" {
+			errs = append(errs, fmt.Errorf("disk without device specified"))
+			continue
+		}
+		device := *disk.Device
+		size, ok := deviceSize(device)
+		if !ok {
+			errs = append(errs, fmt.Errorf("disk %s does not exist", device))
+			continue
+		}
+
+		errs = append(errs, validateMBRDisk(disk)...)
+
+		var (
+			required uint64
+			numbers  = map[int64]bool{}
+			fillers  int
+		)
+		for _, p := range disk.Partitions {
+			if p.Number == nil {
+				errs = append(errs, fmt.Errorf("partition %q on disk %s has no number", p.Label, device))
+				continue
+			}
+			if numbers[*p.Number] {
+				errs = append(errs, fmt.Errorf("partition number %d on disk %s is used more than once", *p.Number, device))
+			}
+			numbers[*p.Number] = true
+			devices[partitionDevice(device, *p.Number)] = true
+
+			if p.Size == nil {
+				continue
+			}
+			if *p.Size < 0 {
+				errs = append(errs, fmt.Errorf("partition %d on disk %s has negative size %d", *p.Number, device, *p.Size))
+				continue
+			}
+			if *p.Size == 0 {
+				fillers++
+				continue
+			}
+			required += uint64(*p.Size) * mib // nolint:gosec
+		}
+		if fillers > 1 {
+			errs = append(errs, fmt.Errorf("disk %s has %d partitions which should fill the remaining space, only one is possible", device, fillers))
+		}
+		if fillers > 0 {
+			// the remaining partition requires at least one MiB
+			required += mib
+		}
+		if required+gptOverhead > size {
+			errs = append(errs, fmt.Errorf("partitions on disk %s require %d MiB, but disk has only %d MiB", device, (required+gptOverhead)/mib, size/mib))
+		}
+	}
+
+	for _, raid := range config.Raid {
+		if raid.Arrayname == nil || *raid.Arrayname == "" {
+			continue
+		}
+		name := *raid.Arrayname
+		level := "1"
+		if raid.Level != nil {
+			level = *raid.Level
+		}
+		spares := 0
+		if raid.Spares != nil {
+			spares = int(*raid.Spares)
+		}
+
+		minimum, ok := raidMinimumDevices[level]
+		if !ok {
+			errs = append(errs, fmt.Errorf("raid %s has unsupported level %q", name, level))
+		}
+		if spares < 0 {
+			errs = append(errs, fmt.Errorf("raid %s has negative number of spares %d", name, spares))
+		}
+		if spares > 0 && level == "0" {
+			errs = append(errs, fmt.Errorf("raid %s with level 0 can not have spares", name))
+		}
+		active := len(raid.Devices) - spares
+		if ok && active < minimum {
+			errs = append(errs, fmt.Errorf("raid %s with level %s requires at least %d active devices, got %d", name, level, minimum, active))
+		}
+		for _, d := range raid.Devices {
+			if !known(d) {
+				errs = append(errs, fmt.Errorf("raid %s member %s does not exist", name, d))
+			}
+		}
+		if devices[name] {
+			errs = append(errs, fmt.Errorf("raid %s is defined more than once", name))
+		}
+		devices[name] = true
+	}
+
+	vgs := map[string][]string{}
+	for _, vg := range config.Volumegroups {
+		if vg.Name == nil || *vg.Name == "" {
+			continue
+		}
+		if len(vg.Devices) == 0 {
+			errs = append(errs, fmt.Errorf("volumegroup %s has no devices", *vg.Name))
+		}
+		for _, d := range vg.Devices {
+			if !known(d) {
+				errs = append(errs, fmt.Errorf("volumegroup %s device %s does not exist", *vg.Name, d))
+			}
+		}
+		vgs[*vg.Name] = vg.Devices
+	}
+
+	for _, lv := range config.Logicalvolumes {
+		if lv.Name == nil || *lv.Name == "" || lv.Volumegroup == nil || *lv.Volumegroup == "" {
+			continue
+		}
+		pvs, ok := vgs[*lv.Volumegroup]
+		if !ok {
+			errs = append(errs, fmt.Errorf("logical volume %s references unknown volumegroup %s", *lv.Name, *lv.Volumegroup))
+		} else {
+			errs = append(errs, validateLogicalVolume(lv, pvs, config.Logicalvolumes)...)
+		}
+		devices[logicalVolumeDevice(*lv.Volumegroup, *lv.Name)] = true
+		devices[filepath.Join("/dev", "mapper", strings.ReplaceAll(*lv.Volumegroup, "-", "--")+"-"+strings.ReplaceAll(*lv.Name, "-", "--"))] = true
+	}
+
+	paths := map[string]string{}
+	for _, fs := range config.Filesystems {
+		if fs.Format == nil || *fs.Format == "" {
+			errs = append(errs, fmt.Errorf("filesystem %q has no format", filesystemName(fs)))
+			continue
+		}
+		format := *fs.Format
+		if !slices.Contains(supportedFormats, format) {
+			errs = append(errs, fmt.Errorf("filesystem %q has unsupported format %q", filesystemName(fs), format))
+		}
+		if format != "tmpfs" {
+			if fs.Device == nil || *fs.Device == "" {
+				errs = append(errs, fmt.Errorf("filesystem %q has no device", filesystemName(fs)))
+			} else if !known(*fs.Device) {
+				errs = append(errs, fmt.Errorf("filesystem %q device %s does not exist", filesystemName(fs), *fs.Device))
+			}
+		}
+		if fs.Path == "" {
+			continue
+		}
+		p := filepath.Clean(fs.Path)
+		if other, ok := paths[p]; ok {
+			errs = append(errs, fmt.Errorf("filesystem %q and %q are both mounted at %s", other, filesystemName(fs), p))
+			continue
+		}
+		paths[p] = filesystemName(fs)
+	}
+
+	return errs
+}
+
+// sysfsDeviceSize reads the size of a block device from sysfs, symlinks like /dev/vg/lv are resolved.
+func sysfsDeviceSize(device string) (uint64, bool) {
+	name := device
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		name = resolved
+	}
+	sysfs, err := sysfsBlockDevice(name)
+	if err != nil {
+		return 0, false
+	}
+	content, err := gos.ReadFile(filepath.Join(sysfs, "size"))
+	if err != nil {
+		return 0, false
+	}
+	sectors, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	// sysfs always reports the size in 512 byte sectors
+	return sectors * 512, true
+}
+
+// partitionDevice returns the device of the partition with the given number,
+// disks which end with a digit like nvme0n1 get a "p" in between.
+func partitionDevice(disk string, number int64) string {
+	if disk != "" && disk[len(disk)-1] >= '0' && disk[len(disk)-1] <= '9' {
+		return fmt.Sprintf("%sp%d", disk, number)
+	}
+	return fmt.Sprintf("%s%d", disk, number)
+}
+
+func logicalVolumeDevice(vg, lv string) string {
+	return filepath.Join("/dev", vg, lv)
+}
+
+func filesystemName(fs *models.V1Filesystem) string {
+	if fs.Label != "" {
+		return fs.Label
+	}
+	if fs.Path != "" {
+		return fs.Path
+	}
+	if fs.Device != nil {
+		return *fs.Device
+	}
+	return "unknown"
+}
+
+func layoutName(config models.V1FilesystemLayoutResponse) string {
+	if config.ID != nil {
+		return *config.ID
+	}
+	return config.Name
+}
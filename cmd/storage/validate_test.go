@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/metal-stack/metal-go/api/models"
+)
+
+func TestValidateLayout(t *testing.T) {
+	gib := uint64(1024) * mib
+	hardware := map[string]uint64{
+		"/dev/sda":     100 * gib,
+		"/dev/sdb":     100 * gib,
+		"/dev/nvme0n1": 10 * gib,
+	}
+	deviceSize := func(device string) (uint64, bool) {
+		size, ok := hardware[device]
+		return size, ok
+	}
+
+	tests := []struct {
+		name    string
+		config  models.V1FilesystemLayoutResponse
+		wantErr []string
+	}{
+		{
+			name: "valid raid1 layout",
+			config: models.V1FilesystemLayoutResponse{
+				Disks: []*models.V1Disk{
+					{Device: strPtr("/dev/sda"), Partitions: []*models.V1DiskPartition{{Number: int64Ptr(1), Label: "efi", Size: int64Ptr(500)}, {Number: int64Ptr(2), Label: "root", Size: int64Ptr(0)}}},
+					{Device: strPtr("/dev/sdb"), Partitions: []*models.V1DiskPartition{{Number: int64Ptr(1), Label: "efi", Size: int64Ptr(500)}, {Number: int64Ptr(2), Label: "root", Size: int64Ptr(0)}}},
+				},
+				Raid: []*models.V1Raid{
+					{Arrayname: strPtr("/dev/md1"), Devices: []string{"/dev/sda1", "/dev/sdb1"}, Level: strPtr("1")},
+					{Arrayname: strPtr("/dev/md2"), Devices: []string{"/dev/sda2", "/dev/sdb2"}, Level: strPtr("1")},
+				},
+				Filesystems: []*models.V1Filesystem{
+					{Device: strPtr("/dev/md1"), Format: strPtr("vfat"), Label: "efi", Path: "/boot/efi"},
+					{Device: strPtr("/dev/md2"), Format: strPtr("ext4"), Label: "root", Path: "/"},
+					{Format: strPtr("tmpfs"), Path: "/tmp"},
+				},
+			},
+		},
+		{
+			name: "valid lvm on nvme layout",
+			config: models.V1FilesystemLayoutResponse{
+				Disks: []*models.V1Disk{
+					{Device: strPtr("/dev/nvme0n1"), Partitions: []*models.V1DiskPartition{{Number: int64Ptr(1), Label: "efi", Size: int64Ptr(500)}, {Number: int64Ptr(2), Label: "lvm", Size: int64Ptr(0)}}},
+				},
+				Volumegroups: []*models.V1VolumeGroup{{Name: strPtr("csi-lvm"), Devices: []string{"/dev/nvme0n1p2"}}},
+				Logicalvolumes: []*models.V1LogicalVolume{
+					{Name: strPtr("root"), Volumegroup: strPtr("csi-lvm"), Size: int64Ptr(5000)},
+				},
+				Filesystems: []*models.V1Filesystem{
+					{Device: strPtr("/dev/nvme0n1p1"), Format: strPtr("vfat"), Label: "efi", Path: "/boot/efi"},
+					{Device: strPtr("/dev/csi-lvm/root"), Format: strPtr("ext4"), Label: "root", Path: "/"},
+				},
+			},
+		},
+		{
+			name: "valid thin pool and cache layout",
+			config: models.V1FilesystemLayoutResponse{
+				Volumegroups: []*models.V1VolumeGroup{
+					{Name: strPtr("thin"), Devices: []string{"/dev/sda"}},
+					{Name: strPtr("cached"), Devices: []string{"/dev/sdb", "/dev/nvme0n1"}},
+				},
+				Logicalvolumes: []*models.V1LogicalVolume{
+					{Name: strPtr("thin1"), Volumegroup: strPtr("thin"), Size: int64Ptr(10000), Lvmtype: strPtr("thin")},
+					{Name: strPtr("pool"), Volumegroup: strPtr("thin"), Size: int64Ptr(0), Lvmtype: strPtr("thin-pool")},
+					{Name: strPtr("data"), Volumegroup: strPtr("cached"), Size: int64Ptr(0), Lvmtype: strPtr("writecache")},
+				},
+			},
+		},
+		{
+			name: "lvmtypes not matching the pvs",
+			config: models.V1FilesystemLayoutResponse{
+				Volumegroups: []*models.V1VolumeGroup{
+					{Name: strPtr("vg"), Devices: []string{"/dev/sda", "/dev/sdb", "/dev/nvme0n1"}},
+				},
+				Logicalvolumes: []*models.V1LogicalVolume{
+					{Name: strPtr("r6"), Volumegroup: strPtr("vg"), Size: int64Ptr(0), Lvmtype: strPtr("raid6")},
+					{Name: strPtr("r10"), Volumegroup: strPtr("vg"), Size: int64Ptr(0), Lvmtype: strPtr("raid10")},
+					{Name: strPtr("thin"), Volumegroup: strPtr("vg"), Size: int64Ptr(100), Lvmtype: strPtr("thin")},
+					{Name: strPtr("r5"), Volumegroup: strPtr("vg"), Size: int64Ptr(0), Lvmtype: strPtr("raid5")},
+					{Name: strPtr("unknown"), Volumegroup: strPtr("vg"), Size: int64Ptr(0), Lvmtype: strPtr("mirror")},
+				},
+			},
+			wantErr: []string{
+				"logical volume r6 with lvmtype raid6 requires at least 5 pvs in volumegroup vg, got 3",
+				"logical volume r10 with lvmtype raid10 requires at least 4 pvs in volumegroup vg, got 3",
+				"logical volume r10 with lvmtype raid10 requires an even number of pvs, got 3",
+				"logical volume thin: volumegroup vg must contain exactly one thin-pool for thin volumes, got 0",
+				"logical volume unknown has unsupported lvmtype \"mirror\"",
+			},
+		},
+		{
+			name: "all errors are reported",
+			config: models.V1FilesystemLayoutResponse{
+				Disks: []*models.V1Disk{
+					{Device: strPtr("/dev/sdc")},
+					{Device: strPtr("/dev/nvme0n1"), Partitions: []*models.V1DiskPartition{{Number: int64Ptr(1), Size: int64Ptr(20000)}, {Number: int64Ptr(2), Size: int64Ptr(0)}, {Number: int64Ptr(3), Size: int64Ptr(0)}}},
+				},
+				Raid: []*models.V1Raid{
+					{Arrayname: strPtr("/dev/md1"), Devices: []string{"/dev/sda1", "/dev/sdb1"}, Level: strPtr("5")},
+					{Arrayname: strPtr("/dev/md2"), Devices: []string{"/dev/nvme0n1p1", "/dev/nvme0n1p2"}, Level: strPtr("7")},
+				},
+				Volumegroups: []*models.V1VolumeGroup{{Name: strPtr("vg"), Devices: []string{"/dev/sdd1"}}},
+				Logicalvolumes: []*models.V1LogicalVolume{
+					{Name: strPtr("root"), Volumegroup: strPtr("other")},
+				},
+				Filesystems: []*models.V1Filesystem{
+					{Device: strPtr("/dev/md2"), Format: strPtr("xfs"), Label: "root", Path: "/"},
+					{Format: strPtr("ext4"), Label: "var", Path: "/var"},
+					{Device: strPtr("/dev/md1"), Format: strPtr("ext4"), Label: "data", Path: "/var/"},
+				},
+			},
+			wantErr: []string{
+				"disk /dev/sdc does not exist",
+				"partitions on disk /dev/nvme0n1 require 20003 MiB, but disk has only 10240 MiB",
+				"disk /dev/nvme0n1 has 2 partitions which should fill the remaining space",
+				"raid /dev/md1 with level 5 requires at least 3 active devices, got 2",
+				"raid /dev/md1 member /dev/sda1 does not exist",
+				"raid /dev/md1 member /dev/sdb1 does not exist",
+				"raid /dev/md2 has unsupported level \"7\"",
+				"volumegroup vg device /dev/sdd1 does not exist",
+				"logical volume root references unknown volumegroup other",
+				"filesystem \"root\" has unsupported format \"xfs\"",
+				"filesystem \"var\" has no device",
+				"filesystem \"var\" and \"data\" are both mounted at /var",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateLayout(tt.config, deviceSize)
+			if len(errs) != len(tt.wantErr) {
+				t.Errorf("validateLayout() got %d errors, want %d: %v", len(errs), len(tt.wantErr), errs)
+			}
+			for _, want := range tt.wantErr {
+				found := false
+				for _, err := range errs {
+					if strings.Contains(err.Error(), want) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("validateLayout() error %q not found in %v", want, errs)
+				}
+			}
+		})
+	}
+}
+
+func TestPartitionDevice(t *testing.T) {
+	if got := partitionDevice("/dev/sda", 2); got != "/dev/sda2" {
+		t.Errorf("partitionDevice() = %s, want /dev/sda2", got)
+	}
+	if got := partitionDevice("/dev/nvme0n1", 2); got != "/dev/nvme0n1p2" {
+		t.Errorf("partitionDevice() = %s, want /dev/nvme0n1p2", got)
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
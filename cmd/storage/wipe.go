@@ -2,12 +2,18 @@ package storage
 
 import (
 	"context"
+	"crypto"
+	"errors"
 	"fmt"
 	"log/slog"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	gos "os"
 
+	"github.com/metal-stack/metal-hammer/pkg/api"
 	"github.com/metal-stack/metal-hammer/pkg/os"
 	"github.com/metal-stack/metal-hammer/pkg/os/command"
 	"golang.org/x/sync/errgroup"
@@ -20,25 +26,121 @@ var (
 	DiskPrefixToIgnore = "ram"
 )
 
+// WipePolicy defines how a disk which could not be wiped is handled
+type WipePolicy string
+
+const (
+	// WipePolicyStrict fails the provisioning if a disk could not be wiped, this is the default
+	WipePolicyStrict WipePolicy = "strict"
+	// WipePolicyLenient only reports disks which could not be wiped and continues
+	WipePolicyLenient WipePolicy = "lenient"
+)
+
 type Disks struct {
 	log *slog.Logger
+	// signer signs the wipe certificates, they are not signed if nil
+	signer crypto.Signer
+	// signerSubject is the subject of the certificate of the signer
+	signerSubject string
+	policy        WipePolicy
+	// protected disks are not wiped
+	protected ProtectedDisks
+	// concurrency is the number of disks of the same controller wiped in parallel
+	concurrency int
+	// progress is called with the progress of every disk
+	progress func(WipeProgress)
+	// controllerErases are the erases of whole nvme controllers which are shared by all namespaces
+	controllerErases map[string]*controllerErase
+	// protectedControllers are the nvme controllers with a protected namespace, they are never erased as a whole
+	protectedControllers map[string]bool
+	mu                   sync.Mutex
 }
 
 func NewDisks(log *slog.Logger) *Disks {
-	return &Disks{log: log}
+	return &Disks{log: log, policy: WipePolicyStrict, concurrency: DefaultWipeConcurrency}
 }
 
-// WipeDisks will erase all content and partitions of all existing Disks
-func (d *Disks) Wipe() error {
-	d.log.Info("wipe")
+// WithConcurrency sets the number of disks of the same controller which are wiped in parallel
+func (d *Disks) WithConcurrency(concurrency int) *Disks {
+	if concurrency > 0 {
+		d.concurrency = concurrency
+	}
+	return d
+}
+
+// WithProgress calls progress regularly with the progress of every disk
+func (d *Disks) WithProgress(progress func(WipeProgress)) *Disks {
+	d.progress = progress
+	return d
+}
+
+// WithProtectedDisks excludes disks matching one of the rules from wiping
+func (d *Disks) WithProtectedDisks(protected ProtectedDisks) *Disks {
+	d.protected = protected
+	return d
+}
+
+// WithPolicy sets the policy for disks which could not be wiped
+func (d *Disks) WithPolicy(policy WipePolicy) *Disks {
+	d.policy = policy
+	return d
+}
+
+// WithSigner signs all wipe certificates with the given key
+func (d *Disks) WithSigner(signer crypto.Signer, subject string) *Disks {
+	d.signer = signer
+	d.signerSubject = subject
+	return d
+}
+
+// wipeMethod erases a disk, methods are tried in order until the wipe could be verified
+type wipeMethod struct {
+	name  string
+	erase func() error
+	// crypto erases are verified by the completion status of the erase, the data can not be verified by a pattern
+	crypto bool
+}
+
+// blockDisks returns all disks of the machine, tests replace it to return fake disks
+var blockDisks = func() ([]*ghw.Disk, error) {
 	block, err := ghw.Block()
 	if err != nil {
-		return fmt.Errorf("unable to gather disks %w", err)
+		return nil, err
+	}
+	return block.Disks, nil
+}
+
+// wipeMethods returns the erase methods of a disk, tests replace it to stub the erases
+var wipeMethods = (*Disks).methods
+
+// WipeDisks will erase all content and partitions of all existing Disks.
+// A wipe certificate is returned for every disk, also if an error is returned.
+// With the strict policy an error is returned if any disk could not be wiped.
+func (d *Disks) Wipe() ([]api.WipeCertificate, error) {
+	d.log.Info("wipe")
+	disks, err := blockDisks()
+	if err != nil {
+		return nil, fmt.Errorf("unable to gather disks %w", err)
 	}
-	disks := block.Disks
 
 	d.log.Info("wipe existing disks", "disks", disks)
+	var wiped []*ghw.Disk
+	for _, disk := range disks {
+		if _, ok := d.protected.Match(disk); ok || strings.HasPrefix(disk.Name, DiskPrefixToIgnore) {
+			continue
+		}
+		wiped = append(wiped, disk)
+	}
+	d.releaseDevices(wiped)
+	d.protectedControllers = protectedNVMeControllers(disks, d.protected)
 
+	var (
+		certificates []api.WipeCertificate
+		errs         []error
+		mu           sync.Mutex
+		// controllers limits the number of disks wiped in parallel per controller
+		controllers = map[string]chan struct{}{}
+	)
 	g, _ := errgroup.WithContext(context.Background())
 	for _, disk := range disks {
 		disk := disk
@@ -46,49 +148,143 @@ func (d *Disks) Wipe() error {
 			d.log.Info("skip because in ignorelist", "disk", disk.Name)
 			continue
 		}
+		if rule, ok := d.protected.Match(disk); ok {
+			d.log.Warn("skip wipe of protected disk", "disk", disk.Name, "serial", disk.SerialNumber, "model", disk.Model, "rule", rule)
+			mu.Lock()
+			certificates = append(certificates, api.WipeCertificate{
+				Device:    "/dev/" + disk.Name,
+				Serial:    disk.SerialNumber,
+				WWN:       disk.WWN,
+				Model:     disk.Model,
+				Size:      disk.SizeBytes,
+				Method:    "none",
+				Protected: rule.String(),
+			})
+			mu.Unlock()
+			continue
+		}
+		controller := diskController(disk)
+		if _, ok := controllers[controller]; !ok {
+			controllers[controller] = make(chan struct{}, d.concurrency)
+		}
+		limit := controllers[controller]
 		g.Go(func() error {
-			return d.wipe(disk)
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			certificate := d.wipe(disk)
+			mu.Lock()
+			defer mu.Unlock()
+			certificates = append(certificates, certificate)
+			if certificate.Error != "" {
+				errs = append(errs, fmt.Errorf("unable to wipe %s: %s", certificate.Device, certificate.Error))
+			}
+			return nil
 		})
 	}
 
-	err = g.Wait()
+	_ = g.Wait()
+	err = errors.Join(errs...)
 	if err != nil {
-		d.log.Error("failed to wipe disk", "error", err)
+		d.log.Error("failed to wipe disk", "policy", d.policy, "error", err)
+		if d.policy != WipePolicyLenient {
+			return certificates, err
+		}
 	}
 
-	return nil
+	for _, t := range WipeSummary(certificates) {
+		d.log.Info("wipe", "throughput", t.String())
+	}
+
+	return certificates, nil
 }
 
-// bs is the blocksize in bytes to be used by dd
-const bs = uint64(10240)
+// zeroBlockSize is the size of a single write when the disk is overwritten with zeros
+const zeroBlockSize = 4 * mib
 
 // WipeDisk will erase all content and partitions of given existing disk.
-func (d *Disks) wipe(disk *ghw.Disk) error {
+// If the erased disk can not be verified, the next method is tried.
+func (d *Disks) wipe(disk *ghw.Disk) api.WipeCertificate {
 	device := fmt.Sprintf("/dev/%s", disk.Name)
-	bytes := disk.SizeBytes
-	rotational := d.isRotational(disk.Name)
-	if rotational {
-		return d.insecureErase(device, bytes)
+	certificate := api.WipeCertificate{
+		Device:    device,
+		Serial:    disk.SerialNumber,
+		WWN:       disk.WWN,
+		Model:     disk.Model,
+		Size:      disk.SizeBytes,
+		DriveType: strings.ToLower(disk.DriveType.String()),
+		Start:     time.Now(),
 	}
-	if isNVMeDisk(device) {
-		return d.secureEraseNVMe(device)
+
+	d.scrubMetadata(disk)
+
+	var errs []string
+	for _, m := range wipeMethods(d, disk) {
+		certificate.Method = m.name
+		err := m.erase()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", m.name, err))
+			continue
+		}
+		certificate.Verification = verifyErase(m, device, disk.SizeBytes)
+		if certificate.Verification.Error == "" {
+			signatures, err := remainingSignatures(device)
+			if err != nil {
+				certificate.Verification.Error = err.Error()
+			} else if signatures != "" {
+				certificate.Verification.Error = fmt.Sprintf("signatures found: %s", signatures)
+			}
+		}
+		if certificate.Verification.Error == "" {
+			certificate.Verified = true
+			break
+		}
+		d.log.Warn("wipe", "disk", device, "method", m.name, "message", "verification failed", "error", certificate.Verification.Error)
+		errs = append(errs, fmt.Sprintf("%s: verification failed %s", m.name, certificate.Verification.Error))
+	}
+	certificate.End = time.Now()
+	if !certificate.Verified {
+		certificate.Error = strings.Join(errs, ", ")
 	}
-	return d.insecureErase(device, bytes)
-}
 
-// insecureErase will first try to format the device with discard, if this fails
-// overwrite it with dd
-func (d *Disks) insecureErase(device string, bytes uint64) error {
-	err := d.discard(device)
+	err := d.sign(&certificate)
 	if err != nil {
-		return d.wipeSlow(device, bytes)
+		d.log.Error("wipe", "disk", device, "message", "unable to sign wipe certificate", "error", err)
 	}
-	return nil
+	d.log.Info("wipe", "disk", device, "method", certificate.Method, "verified", certificate.Verified, "took", certificate.End.Sub(certificate.Start))
+	return certificate
+}
+
+// methods returns the erase methods for the disk, the fastest first and overwriting with zeros as last resort
+func (d *Disks) methods(disk *ghw.Disk) []wipeMethod {
+	device := fmt.Sprintf("/dev/%s", disk.Name)
+	bytes := disk.SizeBytes
+
+	var methods []wipeMethod
+	rotational := d.isRotational(disk.Name)
+	switch {
+	case isNVMeDisk(device) && !rotational:
+		methods = append(methods, d.nvmeMethods(device, bytes)...)
+	case isATADisk(disk.Name) && !rotational:
+		methods = append(methods,
+			wipeMethod{name: "ata-secure-erase", erase: func() error { return d.secureEraseATA(device) }},
+			wipeMethod{name: "discard", erase: func() error { return d.discard(device, bytes) }},
+		)
+	case isSCSIDisk(disk.Name):
+		methods = append(methods, d.scsiMethods(device, bytes)...)
+		if !rotational {
+			methods = append(methods, wipeMethod{name: "discard", erase: func() error { return d.discard(device, bytes) }})
+		}
+	default:
+		methods = append(methods, wipeMethod{name: "discard", erase: func() error { return d.discard(device, bytes) }})
+	}
+	return append(methods, wipeMethod{name: "overwrite", erase: func() error { return d.wipeSlow(device, bytes) }})
 }
 
-func (d *Disks) discard(device string) error {
+// discard all blocks of the device, devices which do not support discard fail here.
+// The beginning and the end of the disk are overwritten because some devices do not return zeros for discarded blocks.
+func (d *Disks) discard(device string, bytes uint64) error {
 	d.log.Info("wipe", "disk", device, "message", "discard existing data")
-	err := os.ExecuteCommand(command.MKFSExt4, "-F", "-E", "discard", device)
+	err := os.ExecuteCommand(command.BlkDiscard, "--force", device)
 	if err != nil {
 		d.log.Error("wipe", "disk", device, "message", "discard of existing data failed", "error", err)
 		return err
@@ -101,19 +297,41 @@ func (d *Disks) discard(device string) error {
 		return err
 	}
 
+	// the backup gpt, mdadm 1.0 superblocks and lvm metadata reside at the end of the disk
+	err = zeroTail(device, bytes)
+	if err != nil {
+		d.log.Error("wipe", "disk", device, "message", "overwrite of the last bytes of data failed", "error", err)
+		return err
+	}
+
 	d.log.Info("wipe", "disk", device, "message", "finish discard of existing data")
 	return nil
 }
 
+// wipeSlow overwrites the whole disk with zeros
 func (d *Disks) wipeSlow(device string, bytes uint64) error {
 	d.log.Info("wipe", "disk", device, "message", "slow deleting of existing data")
-	count := bytes / bs
-	bsArg := fmt.Sprintf("bs=%d", bs)
-	countArg := fmt.Sprintf("count=%d", count)
-	err := os.ExecuteCommand(command.DD, "status=progress", "if=/dev/zero", "of="+device, bsArg, countArg)
+	f, err := gos.OpenFile(device, gos.O_WRONLY, 0)
 	if err != nil {
-		d.log.Error("wipe", "disk", device, "message", "overwrite of existing data with dd failed", "error", err)
-		return err
+		return fmt.Errorf("unable to open %s %w", device, err)
+	}
+	defer f.Close()
+
+	progress := d.newProgressReporter(device, "overwrite", bytes)
+	zeros := make([]byte, zeroBlockSize)
+	var written uint64
+	for written < bytes {
+		n, err := f.Write(zeros[:min(zeroBlockSize, bytes-written)])
+		written += uint64(n) // nolint:gosec
+		if err != nil {
+			d.log.Error("wipe", "disk", device, "message", "overwrite of existing data failed", "written", written, "error", err)
+			return fmt.Errorf("overwrite of %s failed after %d bytes %w", device, written, err)
+		}
+		progress.update(written)
+	}
+	err = f.Sync()
+	if err != nil {
+		return fmt.Errorf("unable to sync %s %w", device, err)
 	}
 	d.log.Info("wipe", "disk", device, "message", "finish deleting of existing data")
 	return nil
@@ -123,25 +341,8 @@ func isNVMeDisk(device string) bool {
 	return strings.HasPrefix(device, "/dev/nvm")
 }
 
-// Secure erase is done via:
-// nvme-cli --format --force --ses=1 /dev/nvme0n1
-// see: https://github.com/linux-nvme/nvme-cli/blob/master/Documentation/nvme-format.txt
-//
-// TODO: configure qemu to map a disk with the nvme format:
-// https://github.com/nvmecompliance/manage/blob/master/runQemu.sh
-// https://github.com/arunar/nvmeqemu
-func (d *Disks) secureEraseNVMe(device string) error {
-	d.log.Info("wipe", "disk", device, "message", "start very fast deleting of existing data")
-	err := os.ExecuteCommand(command.NVME, "--format", "--force", "--ses=1", device)
-	if err != nil {
-		return fmt.Errorf("unable to secure erase nvme disk %s %w", device, err)
-	}
-	return nil
-}
-
 func (d *Disks) isRotational(deviceName string) bool {
-	sysfsRotational := fmt.Sprintf("/sys/block/%s/queue/rotational", deviceName)
-	rotational, err := gos.ReadFile(sysfsRotational)
+	rotational, err := gos.ReadFile(filepath.Join(sysBlock, deviceName, "queue", "rotational"))
 	result := true
 	if err != nil {
 		// defensive guess, fall back to hdd if unknown
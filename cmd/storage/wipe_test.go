@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"errors"
+	"log/slog"
+	"reflect"
+	"testing"
+
+	"github.com/jaypipes/ghw"
+)
+
+func TestWipePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  WipePolicy
+		failing string
+		wantErr bool
+		// want maps the device of every returned certificate to its error
+		want map[string]string
+	}{
+		{
+			name:   "strict all wiped",
+			policy: WipePolicyStrict,
+			want:   map[string]string{"/dev/testdisk0": "", "/dev/testdisk1": ""},
+		},
+		{
+			name:    "strict aborts if a disk could not be wiped",
+			policy:  WipePolicyStrict,
+			failing: "testdisk1",
+			wantErr: true,
+			want:    map[string]string{"/dev/testdisk0": "", "/dev/testdisk1": "crypto-erase: failed"},
+		},
+		{
+			name:    "lenient only reports a disk which could not be wiped",
+			policy:  WipePolicyLenient,
+			failing: "testdisk1",
+			want:    map[string]string{"/dev/testdisk0": "", "/dev/testdisk1": "crypto-erase: failed"},
+		},
+	}
+
+	originalDisks := blockDisks
+	originalMethods := wipeMethods
+	originalExecute := executeCommand
+	originalOutput := reportOutput
+	originalSysBlock := sysBlock
+	defer func() {
+		blockDisks = originalDisks
+		wipeMethods = originalMethods
+		executeCommand = originalExecute
+		reportOutput = originalOutput
+		sysBlock = originalSysBlock
+	}()
+	blockDisks = func() ([]*ghw.Disk, error) {
+		return []*ghw.Disk{{Name: "testdisk0"}, {Name: "testdisk1"}}, nil
+	}
+	executeCommand = func(name string, arg ...string) error { return nil }
+	// no remaining signatures
+	reportOutput = func(name string, arg ...string) ([]byte, error) { return nil, nil }
+	sysBlock = t.TempDir()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// crypto erases are verified by their completion, the fake devices are never read
+			wipeMethods = func(d *Disks, disk *ghw.Disk) []wipeMethod {
+				erase := func() error { return nil }
+				if disk.Name == tt.failing {
+					erase = func() error { return errors.New("failed") }
+				}
+				return []wipeMethod{{name: "crypto-erase", erase: erase, crypto: true}}
+			}
+
+			certificates, err := NewDisks(slog.Default()).WithPolicy(tt.policy).Wipe()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Wipe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := map[string]string{}
+			for _, c := range certificates {
+				got[c.Device] = c.Error
+				if c.Verified != (c.Error == "") {
+					t.Errorf("Wipe() certificate of %s verified = %v with error %q", c.Device, c.Verified, c.Error)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Wipe() certificates = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
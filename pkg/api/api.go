@@ -1,6 +1,10 @@
 package api
 
-import "github.com/metal-stack/metal-go/api/models"
+import (
+	"time"
+
+	"github.com/metal-stack/metal-go/api/models"
+)
 
 // Bootinfo is written by the installer in the target os to tell us
 // which kernel, initrd and cmdline must be used for kexec
@@ -44,8 +48,128 @@ type InstallerConfig struct {
 	DNSServers []*models.V1DNSServer `yaml:"dns_servers"`
 	// NTPServers for the machine
 	NTPServers []*models.V1NTPServer `yaml:"ntp_servers"`
+	// Firmware is either efi or bios, with bios grub must be installed for i386-pc
+	Firmware string `yaml:"firmware"`
 }
 
+// LayoutVersion is the version of the Layout, it must be increased on incompatible changes.
+const LayoutVersion = "v1"
+
+type (
+	// Layout describes the storage which was created during installation,
+	// it is written to /etc/metal/layout.json in the target os.
+	Layout struct {
+		// Version of the layout format
+		Version string `json:"version"`
+		// ID of the filesystem layout at the metal-api
+		ID string `json:"id"`
+		// Disks which were partitioned
+		Disks []LayoutDisk `json:"disks"`
+		// Raids are the created md arrays
+		Raids []LayoutRaid `json:"raids"`
+		// VolumeGroups are the lvm volume groups
+		VolumeGroups []LayoutVolumeGroup `json:"volumegroups"`
+		// LogicalVolumes are the lvm logical volumes
+		LogicalVolumes []LayoutLogicalVolume `json:"logicalvolumes"`
+		// Filesystems which were created or kept
+		Filesystems []LayoutFilesystem `json:"filesystems"`
+	}
+	LayoutDisk struct {
+		// Device of the disk, e.g. /dev/sda
+		Device string `json:"device"`
+		Serial string `json:"serial"`
+		WWN    string `json:"wwn"`
+		Model  string `json:"model"`
+		// Size in bytes
+		Size uint64 `json:"size"`
+		// PartitionTable is either gpt or dos
+		PartitionTable string            `json:"partitiontable"`
+		Partitions     []LayoutPartition `json:"partitions"`
+	}
+	LayoutPartition struct {
+		// Device of the partition, e.g. /dev/sda1
+		Device   string `json:"device"`
+		Number   int64  `json:"number"`
+		Label    string `json:"label"`
+		PartUUID string `json:"partuuid"`
+		GPTType  string `json:"gpttype"`
+		// Size in bytes
+		Size uint64 `json:"size"`
+	}
+	LayoutRaid struct {
+		// Device of the array, e.g. /dev/md1
+		Device  string   `json:"device"`
+		Level   string   `json:"level"`
+		UUID    string   `json:"uuid"`
+		Devices []string `json:"devices"`
+		Spares  int32    `json:"spares"`
+	}
+	LayoutVolumeGroup struct {
+		Name    string   `json:"name"`
+		UUID    string   `json:"uuid"`
+		Devices []string `json:"devices"`
+		Tags    []string `json:"tags"`
+	}
+	LayoutLogicalVolume struct {
+		Name        string `json:"name"`
+		VolumeGroup string `json:"volumegroup"`
+		// Device of the logical volume, e.g. /dev/vg/lv
+		Device string `json:"device"`
+		UUID   string `json:"uuid"`
+		Type   string `json:"type"`
+		// Size in bytes
+		Size uint64 `json:"size"`
+	}
+	LayoutFilesystem struct {
+		Device       string   `json:"device"`
+		Path         string   `json:"path"`
+		Format       string   `json:"format"`
+		Label        string   `json:"label"`
+		UUID         string   `json:"uuid"`
+		MountOptions []string `json:"mountoptions"`
+		// Kept is true if the filesystem was already present and not formatted
+		Kept bool `json:"kept"`
+	}
+)
+
+type (
+	// WipeCertificate documents the erasure of a disk, it is signed with the key of the metal-api client certificate.
+	WipeCertificate struct {
+		Device string `json:"device"`
+		Serial string `json:"serial"`
+		WWN    string `json:"wwn"`
+		Model  string `json:"model"`
+		// Size in bytes
+		Size uint64 `json:"size"`
+		// DriveType is the drive type reported by the kernel, e.g. hdd or ssd
+		DriveType string `json:"drivetype"`
+		// Method which erased the disk, e.g. discard, overwrite or nvme-format
+		Method       string           `json:"method"`
+		Start        time.Time        `json:"start"`
+		End          time.Time        `json:"end"`
+		Verified     bool             `json:"verified"`
+		Verification WipeVerification `json:"verification"`
+		// Error is set if the disk could not be erased
+		Error string `json:"error,omitempty"`
+		// Protected is the rule which excluded the disk from wiping
+		Protected string `json:"protected,omitempty"`
+		// Signer is the subject of the certificate whose key created the signature
+		Signer string `json:"signer,omitempty"`
+		// Signature is the base64 encoded signature of the sha256 hash of the certificate without signature
+		Signature string `json:"signature,omitempty"`
+	}
+	// WipeVerification is the result of reading back samples of the erased disk
+	WipeVerification struct {
+		// Samples is the number of blocks read, including the beginning and the end of the disk
+		Samples int `json:"samples"`
+		// Pattern is the byte all samples consist of, e.g. 0x00
+		Pattern string `json:"pattern"`
+		// Status is the completion status reported by the disk, crypto erases are verified by it instead of a pattern
+		Status string `json:"status,omitempty"`
+		Error  string `json:"error,omitempty"`
+	}
+)
+
 // FIXME legacy structs remove once old images are gone
 
 type (
@@ -96,6 +96,14 @@ func Reboot() error {
 	return nil
 }
 
+// PowerOff powers off the server
+func PowerOff() error {
+	if err := unix.Reboot(unix.LINUX_REBOOT_CMD_POWER_OFF); err != nil {
+		return fmt.Errorf("unable to power off %w", err)
+	}
+	return nil
+}
+
 // Firmware returns either efi or bios, depending on the boot method.
 func Firmware() string {
 	_, err := os.Stat(sysfirmware)
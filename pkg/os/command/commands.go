@@ -6,27 +6,39 @@ import (
 )
 
 const (
-	BlkID    = "blkid"
-	DD       = "dd"
-	MDADM    = "mdadm"
-	LVM      = "lvm"
-	Ethtool  = "ethtool"
-	HDParm   = "hdparm"
-	IPMITool = "ipmitool"
-	MKFSExt3 = "mkfs.ext3"
-	MKFSExt4 = "mkfs.ext4"
-	MKFSVFat = "mkfs.vfat"
-	MKSwap   = "mkswap"
-	NVME     = "nvme"
-	SGDisk   = "sgdisk"
-	SSHD     = "sshd"
-	SUM      = "sum"
-	WIPEFS   = "wipefs"
+	BlkID      = "blkid"
+	BlkDiscard = "blkdiscard"
+	DD         = "dd"
+	DMSetup    = "dmsetup"
+	E2FSCK     = "e2fsck"
+	MDADM      = "mdadm"
+	LVM        = "lvm"
+	Ethtool    = "ethtool"
+	HDParm     = "hdparm"
+	IPMITool   = "ipmitool"
+	MKFSExt3   = "mkfs.ext3"
+	MKFSExt4   = "mkfs.ext4"
+	MKFSVFat   = "mkfs.vfat"
+	MKSwap     = "mkswap"
+	NVME       = "nvme"
+	SFDisk     = "sfdisk"
+	SGDisk     = "sgdisk"
+	SGFormat   = "sg_format"
+	SGOpcodes  = "sg_opcodes"
+	SGRequests = "sg_requests"
+	SGSanitize = "sg_sanitize"
+	SmartCtl   = "smartctl"
+	SSHD       = "sshd"
+	SUM        = "sum"
+	WIPEFS     = "wipefs"
 )
 
 var commands = []string{
 	BlkID,
+	BlkDiscard,
 	DD,
+	DMSetup,
+	E2FSCK,
 	MDADM,
 	LVM,
 	Ethtool,
@@ -37,7 +49,13 @@ var commands = []string{
 	MKFSVFat,
 	MKSwap,
 	NVME,
+	SFDisk,
 	SGDisk,
+	SGFormat,
+	SGOpcodes,
+	SGRequests,
+	SGSanitize,
+	SmartCtl,
 	SSHD,
 	SUM,
 	WIPEFS,
@@ -0,0 +1,16 @@
+package os
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadSysfs returns the trimmed content of a sysfs attribute, empty if the attribute can not be read.
+func ReadSysfs(path ...string) string {
+	content, err := os.ReadFile(filepath.Join(path...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,38 @@
+package os
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSysfs(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "numa_node"), []byte("1\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path []string
+		want string
+	}{
+		{
+			name: "attribute is trimmed",
+			path: []string{dir, "numa_node"},
+			want: "1",
+		},
+		{
+			name: "missing attribute",
+			path: []string{dir, "sriov_numvfs"},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReadSysfs(tt.path...); got != tt.want {
+				t.Errorf("ReadSysfs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}